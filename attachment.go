@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// maxImportSize is the largest uploaded file that gets read, in bytes.
+const maxImportSize = 64 * 1024
+
+var importClient = &http.Client{Timeout: 30 * time.Second}
+
+var profileURLRegexp = regexp.MustCompile(`^(?:https?://)?(?:www\.)?letterboxd\.com/([^/]+)/?$`)
+
+// downloadAttachment downloads a file uploaded with a message, if it is no
+// larger than maxImportSize.
+func downloadAttachment(client *http.Client, a *discordgo.MessageAttachment) ([]byte, error) {
+	if a.Size > maxImportSize {
+		return nil, fmt.Errorf("the file is larger than %d KB", maxImportSize/1024)
+	}
+
+	resp, err := client.Get(a.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment '%s': %v", a.Filename, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download attachment '%s': unexpected response status '%d'", a.Filename, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImportSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment '%s': %v", a.Filename, err)
+	}
+	return body, nil
+}
+
+// readUsernames downloads an uploaded CSV or text file and reads the
+// usernames in it.
+func readUsernames(client *http.Client, a *discordgo.MessageAttachment) ([]string, error) {
+	ext := strings.ToLower(path.Ext(a.Filename))
+	if ext != ".csv" && ext != ".txt" {
+		return nil, errors.New("only CSV and text files of usernames can be imported")
+	}
+
+	body, err := downloadAttachment(client, a)
+	if err != nil {
+		return nil, err
+	}
+
+	if ext == ".csv" {
+		return parseUsernamesCSV(string(body))
+	}
+	return parseUsernamesText(string(body)), nil
+}
+
+// parseUsernamesCSV reads the usernames in the first column of a CSV file,
+// skipping a header row named "username".
+func parseUsernamesCSV(text string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+
+	var usernames []string
+	for i, record := range records {
+		username := parseUsername(record[0])
+		if i == 0 && username == "username" {
+			continue
+		}
+		if username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames, nil
+}
+
+// parseUsernamesText reads the usernames in a text file, separated by
+// whitespace or commas.
+func parseUsernamesText(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+
+	var usernames []string
+	for _, field := range fields {
+		if username := parseUsername(field); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames
+}
+
+// parseUsername takes a username or the URL of a Letterboxd profile, as
+// copied from the browser, to the lowercase username.
+func parseUsername(s string) string {
+	s = strings.TrimSpace(s)
+	if match := profileURLRegexp.FindStringSubmatch(s); match != nil {
+		s = match[1]
+	}
+	return strings.ToLower(s)
+}
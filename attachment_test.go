@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseUsernames(t *testing.T) {
+	csv := "Username,Joined\nuser1,2021\n\"https://letterboxd.com/User2/\",2020\n,\nletterboxd.com/user3\n"
+	usernames, err := parseUsernamesCSV(csv)
+	if err != nil {
+		t.Fatalf("failed to parse CSV: %v", err)
+	}
+	if expected := []string{"user1", "user2", "user3"}; !reflect.DeepEqual(usernames, expected) {
+		t.Errorf("CSV: expected %v got %v", expected, usernames)
+	}
+
+	text := "user1 user2,\r\nhttps://www.letterboxd.com/user3\tuser4\n"
+	if expected, got := []string{"user1", "user2", "user3", "user4"}, parseUsernamesText(text); !reflect.DeepEqual(got, expected) {
+		t.Errorf("text: expected %v got %v", expected, got)
+	}
+}
+
+func TestReadUsernames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user1\nuser2\n"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		attachment *discordgo.MessageAttachment
+		expected   []string
+		err        bool
+	}{
+		{&discordgo.MessageAttachment{URL: server.URL, Filename: "members.TXT", Size: 12}, []string{"user1", "user2"}, false},
+		{&discordgo.MessageAttachment{URL: server.URL, Filename: "members.png", Size: 12}, nil, true},
+		{&discordgo.MessageAttachment{URL: server.URL, Filename: "members.csv", Size: maxImportSize + 1}, nil, true},
+	}
+
+	for _, test := range tests {
+		usernames, err := readUsernames(server.Client(), test.attachment)
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.attachment.Filename, test.err, err)
+		}
+		if !reflect.DeepEqual(usernames, test.expected) {
+			t.Errorf("%s: expected %v got %v", test.attachment.Filename, test.expected, usernames)
+		}
+	}
+}
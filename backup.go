@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// backupVersion is the version of the file `!export` makes, raised whenever
+// older versions of the bot could no longer read it.
+const backupVersion = 1
+
+// backup is the file `!export` makes and `!import` reads.
+type backup struct {
+	Version  int             `json:"version"`
+	Channels []backupChannel `json:"channels"`
+}
+
+type backupChannel struct {
+	Channel string   `json:"channel"`
+	Follows []string `json:"follows"`
+	// Settings left out of the file are the defaults
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+var channelMentionRegexp = regexp.MustCompile(`^<#(\d+)>$|^(\d+)$`)
+
+func CmdExport(db *DB, c ChatClient, channel, guild, prefix string) (string, error) {
+	channels, err := db.GuildFollows(guild)
+	if err != nil {
+		return "", fmt.Errorf("failed to get follows of guild '%s': %v\n", guild, err)
+	}
+
+	if len(channels) == 0 {
+		return "Not following anyone in this server.", nil
+	}
+
+	b := backup{Version: backupVersion}
+	for _, ch := range channels {
+		settings, err := db.GetChannelSettings(ch.Channel)
+		if err != nil {
+			return "", fmt.Errorf("failed to get settings of channel '%s': %v\n", ch.Channel, err)
+		}
+
+		raw, err := json.Marshal(settings)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode settings of channel '%s': %v\n", ch.Channel, err)
+		}
+
+		b.Channels = append(b.Channels, backupChannel{ch.Channel, ch.Usernames, raw})
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode follows of guild '%s': %v\n", guild, err)
+	}
+
+	text := fmt.Sprintf("Follows and settings of %d channels in this server, restore them by attaching this file to `%simport`.", len(b.Channels), prefix)
+	if err := c.SendFile(channel, text, "fizzboxd-follows.json", bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("failed to send export of guild '%s': %v\n", guild, err)
+	}
+
+	return "", nil
+}
+
+// CmdImport adds the follows and settings in data, a file made by `!export`,
+// to guild. Follows in the guild that aren't in the file are kept, and new
+// follows start from what their feed has now, like with `!follow`. Users
+// that can't be followed anymore are skipped.
+func CmdImport(db *DB, c ChatClient, src FeedSource, p *bluemonday.Policy, args []string, data []byte, guild, prefix string) (string, error) {
+	usage := fmt.Sprintf("Usage: `%[1]simport [--dry-run]`, with a file made by `%[1]sexport` attached", prefix)
+
+	dryRun := false
+	for _, arg := range args {
+		if strings.ToLower(arg) != "--dry-run" {
+			return usage, nil
+		}
+		dryRun = true
+	}
+
+	if data == nil {
+		return usage, nil
+	}
+
+	var b backup
+	if err := json.Unmarshal(data, &b); err != nil || b.Version == 0 {
+		return "Can't import, the file is not an export of follows.", nil
+	}
+	if b.Version > backupVersion {
+		return "Can't import, the file was exported by a newer version of the bot.", nil
+	}
+
+	var changes []ChannelFollows
+	var lines []string
+
+	// Feeds of new follows are fetched once per user, like with `!follow`
+	feeds := map[string]Feed{}
+	unfollowable := map[string]string{}
+
+	for _, ch := range b.Channels {
+		id := ch.Channel
+		if chGuild, err := c.ChannelGuild(id); err != nil || chGuild != guild {
+			lines = append(lines, fmt.Sprintf("- skip %s, it isn't a channel of this server", escapeMarkdown(id)))
+			continue
+		}
+
+		following, err := db.Following(id)
+		if err != nil {
+			return "", fmt.Errorf("failed to get list of usernames for channel '%s': %v\n", id, err)
+		}
+		followed := map[string]bool{}
+		for _, username := range following {
+			followed[username] = true
+		}
+
+		change := ChannelFollows{Channel: id, History: map[string][]string{}}
+		for _, username := range usernameList(ch.Follows) {
+			if !validUsername(username) {
+				return fmt.Sprintf("Can't import, %s is not a valid username.", escapeMarkdown(username)), nil
+			}
+			if followed[username] {
+				continue
+			}
+
+			// A dry run doesn't seed anything, so it skips the slow fetches
+			if !dryRun {
+				if _, ok := feeds[username]; !ok && unfollowable[username] == "" {
+					feed, resp, err := fetchNewFeed(src, p, username)
+					if err != nil {
+						return resp, err
+					}
+					if resp != "" {
+						unfollowable[username] = resp
+					} else {
+						feeds[username] = feed
+					}
+				}
+
+				if resp := unfollowable[username]; resp != "" {
+					lines = append(lines, fmt.Sprintf("- <#%s>: %s", id, resp))
+					continue
+				}
+				feed := feeds[username]
+				change.History[username] = feed.GetHistory()
+			}
+
+			change.Usernames = append(change.Usernames, username)
+		}
+
+		if len(change.Usernames) > 0 {
+			lines = append(lines, fmt.Sprintf("- <#%s>: follow %s", id, strings.Join(change.Usernames, ", ")))
+		}
+
+		// Settings go away without follows, so there is nothing to set
+		if len(ch.Settings) > 0 && (len(following) > 0 || len(change.Usernames) > 0) {
+			settings := DefaultChannelSettings()
+			if err := json.Unmarshal(ch.Settings, &settings); err != nil {
+				return fmt.Sprintf("Can't import, the settings of <#%s> can't be read.", id), nil
+			}
+			if err := settings.validate(); err != nil {
+				return fmt.Sprintf("Can't import, the settings of <#%s> are invalid: %v.", id, err), nil
+			}
+
+			current, err := db.GetChannelSettings(id)
+			if err != nil {
+				return "", fmt.Errorf("failed to get settings of channel '%s': %v\n", id, err)
+			}
+
+			var changed []string
+			for _, setting := range channelSettings {
+				if value := setting.get(settings); value != setting.get(current) {
+					changed = append(changed, fmt.Sprintf("%s to %s", setting.name, value))
+				}
+			}
+
+			if len(changed) > 0 {
+				change.Settings = &settings
+				lines = append(lines, fmt.Sprintf("- <#%s>: set %s", id, strings.Join(changed, ", ")))
+			}
+		}
+
+		if len(change.Usernames) > 0 || change.Settings != nil {
+			changes = append(changes, change)
+		}
+	}
+
+	if len(changes) == 0 {
+		lines = append([]string{"Nothing to import, this server already has the follows and settings in the file."}, lines...)
+		return truncate(strings.Join(lines, "\n"), messageLimit), nil
+	}
+
+	if dryRun {
+		lines = append([]string{"Importing would make these changes, nothing was changed yet:"}, lines...)
+		return truncate(strings.Join(lines, "\n"), messageLimit), nil
+	}
+
+	if err := db.ImportFollows(guild, changes); err != nil {
+		return "", fmt.Errorf("failed to import follows of guild '%s': %v\n", guild, err)
+	}
+
+	for username, feed := range feeds {
+		if err := db.SetDisplayName(username, feed.DisplayName); err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+
+	lines = append([]string{"Imported these changes:"}, lines...)
+	return truncate(strings.Join(lines, "\n"), messageLimit), nil
+}
+
+// isBackupFile reports whether an attachment named name can be read by
+// `!import`.
+func isBackupFile(name string) bool {
+	return strings.ToLower(path.Ext(name)) == ".json"
+}
+
+func CmdMoveFollows(db *DB, c ChatClient, args []string, channel, guild, prefix string) (string, error) {
+	if len(args) != 1 {
+		return fmt.Sprintf("Usage: `%smove-follows <#channel>`", prefix), nil
+	}
+
+	match := channelMentionRegexp.FindStringSubmatch(args[0])
+	if match == nil {
+		return fmt.Sprintf("Can't find a channel in %s, mention it like #channel.", escapeMarkdown(args[0])), nil
+	}
+	to := match[1] + match[2]
+
+	if to == channel {
+		return "The follows are already in this channel.", nil
+	}
+
+	if toGuild, err := c.ChannelGuild(to); err != nil || toGuild != guild {
+		return fmt.Sprintf("Can't move follows to <#%s>, it isn't a channel of this server.", to), nil
+	}
+
+	moved, skipped, err := db.MoveFollows(channel, to, guild)
+	if err != nil {
+		return "", fmt.Errorf("failed to move follows of channel '%s' to channel '%s': %v\n", channel, to, err)
+	}
+
+	if len(moved) == 0 && len(skipped) == 0 {
+		return "Not following anyone in this channel.", nil
+	}
+
+	var lines []string
+	if len(moved) > 0 {
+		lines = append(lines, fmt.Sprintf("Moved the follows of %s to <#%s>, nothing they posted gets posted again.", strings.Join(moved, ", "), to))
+	}
+	if len(skipped) > 0 {
+		lines = append(lines, fmt.Sprintf("<#%s> already follows %s, those follows were removed here.", to, strings.Join(skipped, ", ")))
+	}
+	return truncate(strings.Join(lines, "\n"), messageLimit), nil
+}
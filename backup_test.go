@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// backupFeedSource serves a feed with film1 for every user but user5.
+func backupFeedSource(t *testing.T) FeedSource {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user5/rss/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(diaryFeed("film1")))
+	}))
+	t.Cleanup(server.Close)
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+	return src
+}
+
+func TestExportImport(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, f := range []struct{ username, channel string }{
+		{"user1", "1"},
+		{"user2", "1"},
+		{"user3", "2"},
+		{"user5", "2"},
+	} {
+		if err := db.Follow(f.username, f.channel, "guild1"); err != nil {
+			t.Fatalf("failed to insert test follow values: %v", err)
+		}
+	}
+	if err := db.Follow("user4", "3", "guild2"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	settings := DefaultChannelSettings()
+	settings.MaxEntries = 2
+	if err := db.SetChannelSettings("1", settings); err != nil {
+		t.Fatalf("failed to set channel settings: %v", err)
+	}
+
+	c := newRecordingClient()
+	if resp, err := CmdExport(db, c, "1", "guild1", "!"); err != nil || resp != "" {
+		t.Fatalf("failed to export: %v %s", err, resp)
+	}
+	if len(c.files["1"]) != 1 {
+		t.Fatalf("expected an exported file, got %v", c.files["1"])
+	}
+	data := []byte(c.files["1"][0])
+
+	// Restore into a server that kept only part of it
+	restored := openTestDB(t)
+	if err := restored.Follow("user1", "1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	c.guilds = map[string]string{"1": "guild1", "2": "guild1", "3": "guild2"}
+	src := backupFeedSource(t)
+
+	// A dry run doesn't fetch the feeds, so it can't tell user5 is missing
+	expected := "Importing would make these changes, nothing was changed yet:\n" +
+		"- <#1>: follow user2\n" +
+		"- <#1>: set max-entries to 2\n" +
+		"- <#2>: follow user3, user5"
+	resp, err := CmdImport(restored, c, src, feedPolicy, []string{"--dry-run"}, data, "guild1", "!")
+	if err != nil || resp != expected {
+		t.Errorf("\nResponse Received: %v\nResponse Expected: %v\nError: %v", resp, expected, err)
+	}
+
+	if following, _ := restored.Following("2"); len(following) != 0 {
+		t.Errorf("dry run followed %v", following)
+	}
+
+	expected = "Imported these changes:\n" +
+		"- <#1>: follow user2\n" +
+		"- <#1>: set max-entries to 2\n" +
+		"- <#2>: Can't follow user5, the Letterboxd user doesn't exist or their profile is private.\n" +
+		"- <#2>: follow user3"
+	resp, err = CmdImport(restored, c, src, feedPolicy, nil, data, "guild1", "!")
+	if err != nil || resp != expected {
+		t.Errorf("unexpected import response: %v %s", err, resp)
+	}
+
+	channels, err := restored.GuildFollows("guild1")
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if got := fmt.Sprint(channels); got != "[{1 [user1 user2] <nil> map[]} {2 [user3] <nil> map[]}]" {
+		t.Errorf("wrong follows after import: %s", got)
+	}
+
+	if got, err := restored.GetChannelSettings("1"); err != nil || got != settings {
+		t.Errorf("expected settings %+v, got %+v (%v)", settings, got, err)
+	}
+
+	// Imported follows start from what their feed has now
+	users, err := restored.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if follows := users["user3"]; len(follows) != 1 || !follows[0].Seeded {
+		t.Errorf("expected a seeded follow of user3, got %v", follows)
+	}
+	if seen, err := restored.Seen("user3", "2", "film1"); err != nil || !seen {
+		t.Errorf("imported follow didn't see the current feed: %v", err)
+	}
+
+	resp, err = CmdImport(restored, c, src, feedPolicy, nil, data, "guild1", "!")
+	if err != nil || resp != "Nothing to import, this server already has the follows and settings in the file.\n- <#2>: Can't follow user5, the Letterboxd user doesn't exist or their profile is private." {
+		t.Errorf("unexpected second import response: %v %s", err, resp)
+	}
+
+	// Channels of other servers are skipped
+	resp, err = CmdImport(restored, c, src, feedPolicy, nil, data, "guild2", "!")
+	if err != nil || resp != "Nothing to import, this server already has the follows and settings in the file.\n- skip 1, it isn't a channel of this server\n- skip 2, it isn't a channel of this server" {
+		t.Errorf("unexpected import response for another server: %v %s", err, resp)
+	}
+
+	// Hints use the prefix of the server
+	resp, err = CmdImport(restored, c, src, feedPolicy, nil, nil, "guild1", "?")
+	if err != nil || resp != "Usage: `?import [--dry-run]`, with a file made by `?export` attached" {
+		t.Errorf("unexpected usage: %v %s", err, resp)
+	}
+
+	for _, bad := range []string{`not json`, `{"version": 2}`, `{"version": 1, "channels": [{"channel": "1", "follows": ["../admin"]}]}`} {
+		resp, err := CmdImport(restored, c, src, feedPolicy, nil, []byte(bad), "guild1", "!")
+		if err != nil || !strings.HasPrefix(resp, "Can't import,") {
+			t.Errorf("%s: expected the import to be refused, got %v %s", bad, err, resp)
+		}
+	}
+}
+
+func TestImportCachedFeed(t *testing.T) {
+	feed := diaryFeed("film1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf("%q", fmt.Sprint(len(feed)))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	db := openTestDB(t)
+	if err := db.Follow("testuser", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if err := db.Seed("testuser", "channel1", nil); err != nil {
+		t.Fatalf("failed to seed follow: %v", err)
+	}
+
+	poll := func(c *recordingClient) {
+		users, err := db.GetFollows()
+		if err != nil {
+			t.Fatalf("failed to get follows: %v", err)
+		}
+		PostUser(context.Background(), user{"testuser", users["testuser"]}, db, c, src, feedPolicy)
+	}
+
+	// The feed is cached by the follow in channel1 before the import
+	poll(newRecordingClient())
+
+	c := newRecordingClient()
+	c.guilds = map[string]string{"channel1": "guild1", "channel2": "guild1"}
+	data := []byte(`{"version": 1, "channels": [{"channel": "channel2", "follows": ["testuser"]}]}`)
+	if resp, err := CmdImport(db, c, src, feedPolicy, nil, data, "guild1", "!"); err != nil || resp != "Imported these changes:\n- <#channel2>: follow testuser" {
+		t.Fatalf("unexpected import response: %v %s", err, resp)
+	}
+
+	// The next poll isn't modified, which must not leave channel2 behind
+	poll(c)
+	if len(c.embeds["channel2"]) != 0 {
+		t.Errorf("expected the import not to post, got %d embeds", len(c.embeds["channel2"]))
+	}
+
+	feed = diaryFeed("film2", "film1")
+	poll(c)
+	for _, channel := range []string{"channel1", "channel2"} {
+		if embeds := c.embeds[channel]; len(embeds) != 1 || !strings.Contains(embeds[0].Description, "film2") {
+			t.Errorf("%s: expected the new entry to be posted, got %v", channel, embeds)
+		}
+	}
+}
+
+func TestCmdMoveFollows(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, f := range []struct{ username, channel string }{
+		{"user1", "1"},
+		{"user2", "1"},
+		{"user2", "2"},
+	} {
+		if err := db.Follow(f.username, f.channel, "guild1"); err != nil {
+			t.Fatalf("failed to insert test follow values: %v", err)
+		}
+	}
+	if err := db.Seed("user1", "1", []string{"film1"}); err != nil {
+		t.Fatalf("failed to seed follow: %v", err)
+	}
+	if _, err := db.RecordSendFailure("user1", "1"); err != nil {
+		t.Fatalf("failed to record send failure: %v", err)
+	}
+	if err := db.DisableFollow("user1", "1", "the channel is gone"); err != nil {
+		t.Fatalf("failed to disable follow: %v", err)
+	}
+
+	settings := DefaultChannelSettings()
+	settings.Color = 0x123456
+	if err := db.SetChannelSettings("1", settings); err != nil {
+		t.Fatalf("failed to set channel settings: %v", err)
+	}
+
+	c := newRecordingClient()
+	c.guilds = map[string]string{"1": "guild1", "2": "guild1", "3": "guild2"}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{}, "Usage: `!move-follows <#channel>`"},
+		{[]string{"<#1>"}, "The follows are already in this channel."},
+		{[]string{"<#3>"}, "Can't move follows to <#3>, it isn't a channel of this server."},
+		{[]string{"<#2>"}, "Moved the follows of user1 to <#2>, nothing they posted gets posted again.\n<#2> already follows user2, those follows were removed here."},
+		{[]string{"2"}, "Not following anyone in this channel."},
+	}
+
+	for _, test := range tests {
+		resp, err := CmdMoveFollows(db, c, test.args, "1", "guild1", "!")
+		if err != nil {
+			t.Errorf("failed to move follows with %v: %v", test.args, err)
+		}
+		if resp != test.expected {
+			t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, test.expected)
+		}
+	}
+
+	// The disabled follow of user1 posts again in its new channel
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	for _, username := range []string{"user1", "user2"} {
+		if follows := users[username]; len(follows) != 1 || follows[0].Channel != "2" {
+			t.Errorf("expected %s to be followed in channel 2 only, got %v", username, follows)
+		}
+	}
+	if !users["user1"][0].Seeded {
+		t.Error("moved follow lost its seeded state")
+	}
+
+	if seen, err := db.Seen("user1", "2", "film1"); err != nil || !seen {
+		t.Errorf("moved follow lost its history: %v", err)
+	}
+
+	if got, err := db.GetChannelSettings("2"); err != nil || got != settings {
+		t.Errorf("expected settings to move along, got %+v (%v)", got, err)
+	}
+
+	if failures, err := db.RecordSendFailure("user1", "2"); err != nil || failures != 1 {
+		t.Errorf("expected send failures to start over after the move, got %d (%v)", failures, err)
+	}
+}
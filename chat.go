@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ChatClient is the part of Discord the bot uses for posting and commands,
+// so that both can be tested without a Discord connection.
+type ChatClient interface {
+	// SendEmbeds sends embeds together as a single message.
+	SendEmbeds(channel string, embeds []*discordgo.MessageEmbed) error
+	SendMessage(channel, text string) error
+	// SendFile sends text with a file attached.
+	SendFile(channel, text, name string, r io.Reader) error
+	// ChannelGuild returns the ID of the guild channel is in.
+	ChannelGuild(channel string) (string, error)
+	// MessagePermissions returns the permissions of the author of m in the
+	// channel it was sent in.
+	MessagePermissions(m *discordgo.Message) (int64, error)
+}
+
+// discordClient implements ChatClient on top of a discordgo session.
+type discordClient struct {
+	s *discordgo.Session
+}
+
+func NewDiscordClient(s *discordgo.Session) ChatClient {
+	return &discordClient{s}
+}
+
+func (c *discordClient) SendEmbeds(channel string, embeds []*discordgo.MessageEmbed) error {
+	_, err := c.s.ChannelMessageSendComplex(channel, &discordgo.MessageSend{Embeds: embeds})
+	return err
+}
+
+// SendMessage sends text without pinging anyone, mentions in it are only
+// shown.
+func (c *discordClient) SendMessage(channel, text string) error {
+	_, err := c.s.ChannelMessageSendComplex(channel, &discordgo.MessageSend{
+		Content:         text,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	return err
+}
+
+func (c *discordClient) SendFile(channel, text, name string, r io.Reader) error {
+	_, err := c.s.ChannelMessageSendComplex(channel, &discordgo.MessageSend{
+		Content:         text,
+		Files:           []*discordgo.File{{Name: name, Reader: r}},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	return err
+}
+
+func (c *discordClient) ChannelGuild(channel string) (string, error) {
+	ch, err := c.s.State.Channel(channel)
+	if err != nil {
+		// Fall back to the API for channels missing from the state
+		ch, err = c.s.Channel(channel)
+		if err != nil {
+			return "", err
+		}
+	}
+	return ch.GuildID, nil
+}
+
+func (c *discordClient) MessagePermissions(m *discordgo.Message) (int64, error) {
+	return c.s.State.MessagePermissions(m)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// recordingClient is a ChatClient that records everything sent to it.
+type recordingClient struct {
+	lock     sync.Mutex
+	embeds   map[string][]*discordgo.MessageEmbed
+	messages map[string][]string
+	// sends counts the embed messages per channel
+	sends map[string]int
+	// files has the content of the files sent per channel
+	files map[string][]string
+
+	// guilds maps channels to the guild returned by ChannelGuild
+	guilds map[string]string
+
+	// perms is returned by MessagePermissions and err by every send
+	perms int64
+	err   error
+}
+
+func newRecordingClient() *recordingClient {
+	return &recordingClient{
+		embeds:   map[string][]*discordgo.MessageEmbed{},
+		messages: map[string][]string{},
+		sends:    map[string]int{},
+		files:    map[string][]string{},
+		guilds:   map[string]string{},
+	}
+}
+
+func (c *recordingClient) SendEmbeds(channel string, embeds []*discordgo.MessageEmbed) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	c.embeds[channel] = append(c.embeds[channel], embeds...)
+	c.sends[channel]++
+	return nil
+}
+
+func (c *recordingClient) SendMessage(channel, text string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	c.messages[channel] = append(c.messages[channel], text)
+	return nil
+}
+
+func (c *recordingClient) SendFile(channel, text, name string, r io.Reader) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	c.messages[channel] = append(c.messages[channel], text)
+	c.files[channel] = append(c.files[channel], string(content))
+	return nil
+}
+
+func (c *recordingClient) ChannelGuild(channel string) (string, error) {
+	guild, ok := c.guilds[channel]
+	if !ok {
+		return "", errors.New("unknown channel")
+	}
+	return guild, nil
+}
+
+func (c *recordingClient) MessagePermissions(m *discordgo.Message) (int64, error) {
+	return c.perms, nil
+}
+
+func TestHandleMessage(t *testing.T) {
+	db = openTestDB(t)
+	defer func() { db = nil }()
+
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	message := func(content string) *discordgo.Message {
+		return &discordgo.Message{
+			ChannelID: "channel1",
+			GuildID:   "guild1",
+			Content:   content,
+			Author:    &discordgo.User{ID: "user1"},
+		}
+	}
+
+	c := newRecordingClient()
+
+	// Only administrators may unfollow until they grant others access
+	handleMessage(c, "bot1", message("!unfollow username1"))
+	handleMessage(c, "bot1", message("!following"))
+	handleMessage(c, "bot1", message("not a command"))
+
+	c.perms = discordgo.PermissionAdministrator
+	handleMessage(c, "bot1", message("!permissions grant <@&111>"))
+
+	c.perms = 0
+	manager := message("!UNFOLLOW username1")
+	manager.Member = &discordgo.Member{Roles: []string{"222", "111"}}
+	handleMessage(c, "bot1", manager)
+
+	expected := []string{
+		notAuthorized,
+		"Following the following Letterboxd usernames in this channel: username1",
+		"Members with <@&111> can now manage follows and settings in this server.",
+		"username1 is no longer being followed in this channel.",
+	}
+
+	got := c.messages["channel1"]
+	if len(got) != len(expected) {
+		t.Fatalf("expected messages %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("\nMessage Received: %v\nMessage Expected: %v", got[i], expected[i])
+		}
+	}
+}
+
+func TestHandleMessagePrefix(t *testing.T) {
+	db = openTestDB(t)
+	defer func() { db = nil }()
+
+	message := func(content string) *discordgo.Message {
+		return &discordgo.Message{
+			ChannelID: "channel1",
+			GuildID:   "guild1",
+			Content:   content,
+			Author:    &discordgo.User{ID: "user1"},
+		}
+	}
+
+	c := newRecordingClient()
+	c.perms = discordgo.PermissionAdministrator
+
+	handleMessage(c, "bot1", message("!prefix <@bot1>"))
+	handleMessage(c, "bot1", message("!prefix ?"))
+	handleMessage(c, "bot1", message("!following"))
+	handleMessage(c, "bot1", message("?following"))
+	handleMessage(c, "bot1", message("<@bot1> following"))
+	handleMessage(c, "bot1", message("<@!bot1>following"))
+	handleMessage(c, "bot1", message("<@bot2> following"))
+
+	expected := []string{
+		"Can't use <@bot1> as prefix, it must be at most 5 characters without any of `@#<>` or backticks.",
+		"Commands in this server now start with `?`, like `?help`.",
+		"Not following anyone in this channel.",
+		"Not following anyone in this channel.",
+		"Not following anyone in this channel.",
+	}
+
+	got := c.messages["channel1"]
+	if len(got) != len(expected) {
+		t.Fatalf("expected messages %q, got %q", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("\nMessage Received: %v\nMessage Expected: %v", got[i], expected[i])
+		}
+	}
+
+	// Help shows the prefix of the guild
+	handleMessage(c, "bot1", message("?help"))
+	help := c.messages["channel1"][len(expected)]
+	if !strings.HasPrefix(help, "**?follow <username>") || strings.Contains(help, "!") {
+		t.Errorf("help doesn't use the prefix of the guild:\n%s", help)
+	}
+}
@@ -3,12 +3,13 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"strings"
 	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// schema is the first migration, see migrations.
 const schema = `
 PRAGMA foreign_keys;
 
@@ -39,6 +40,27 @@ CREATE TABLE IF NOT EXISTS Follows (
 	FOREIGN KEY (channel_id) REFERENCES Channels(id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS FeedCache (
+	username_id INTEGER PRIMARY KEY,
+	etag TEXT NOT NULL DEFAULT '',
+	last_modified TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (username_id) REFERENCES Usernames(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS FetchFailures (
+	username_id INTEGER PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT '',
+	last_failure DATETIME,
+	FOREIGN KEY (username_id) REFERENCES Usernames(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS Profiles (
+	username_id INTEGER PRIMARY KEY,
+	display_name TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY (username_id) REFERENCES Usernames(id) ON DELETE CASCADE
+);
+
 CREATE TRIGGER IF NOT EXISTS CleanGuilds
 AFTER DELETE ON Channels
 WHEN (SELECT COUNT(*) FROM Channels WHERE guild_id = OLD.guild_id) = 0
@@ -59,6 +81,24 @@ WHEN (SELECT COUNT(*) FROM Follows WHERE username_id = OLD.username_id) = 0
 BEGIN
 	DELETE FROM Usernames WHERE id = OLD.username_id;
 END;
+
+CREATE TRIGGER IF NOT EXISTS CleanFeedCache
+AFTER DELETE ON Usernames
+BEGIN
+	DELETE FROM FeedCache WHERE username_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS CleanFetchFailures
+AFTER DELETE ON Usernames
+BEGIN
+	DELETE FROM FetchFailures WHERE username_id = OLD.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS CleanProfiles
+AFTER DELETE ON Usernames
+BEGIN
+	DELETE FROM Profiles WHERE username_id = OLD.id;
+END;
 `
 
 type DB struct {
@@ -68,11 +108,23 @@ type DB struct {
 
 type Follow struct {
 	Channel string
-	History []string
+	// Seeded is false until the entries the feed had when the user was
+	// followed were recorded, nothing is posted before that
+	Seeded bool
+	// ListsSeeded is false for follows from before lists were posted, until
+	// the lists their feed has were recorded
+	ListsSeeded bool
 }
 
 type Users map[string][]Follow
 
+// FetchFailure records the consecutive failed feed fetches of a username.
+type FetchFailure struct {
+	Failures    int
+	LastError   string
+	LastFailure time.Time
+}
+
 func OpenSQLDB(driver, source string) (*DB, error) {
 	sqlDB, err := sql.Open(driver, source)
 	if err != nil {
@@ -98,20 +150,16 @@ func (db *DB) init() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	tx, err := db.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.Exec(schema); err != nil {
-		return fmt.Errorf("failed to initialize schema: %v", err)
-	}
-
-	return tx.Commit()
+	return db.migrate()
 }
 
 func (db *DB) Follow(username, channel, guild string) error {
+	return db.FollowMany([]string{username}, channel, guild)
+}
+
+// FollowMany follows all of usernames in channel in a single transaction, so
+// either all of them are followed or none are.
+func (db *DB) FollowMany(usernames []string, channel, guild string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -121,12 +169,15 @@ func (db *DB) Follow(username, channel, guild string) error {
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("INSERT OR IGNORE INTO Usernames(username) VALUES (?)", username)
-	if err != nil {
-		return fmt.Errorf("failed to add username '%s': %v", username, err)
+	if err := followMany(tx, usernames, channel, guild); err != nil {
+		return err
 	}
 
-	_, err = tx.Exec("INSERT OR IGNORE INTO Guilds(guild) VALUES (?)", guild)
+	return tx.Commit()
+}
+
+func followMany(tx *sql.Tx, usernames []string, channel, guild string) error {
+	_, err := tx.Exec("INSERT OR IGNORE INTO Guilds(guild) VALUES (?)", guild)
 	if err != nil {
 		return fmt.Errorf("failed to add guild '%s': %v", guild, err)
 	}
@@ -137,32 +188,52 @@ func (db *DB) Follow(username, channel, guild string) error {
 		return fmt.Errorf("failed to add channel '%s': %v", channel, err)
 	}
 
-	_, err = tx.Exec(`INSERT INTO Follows(username_id, channel_id)
-		VALUES (
-			(SELECT id FROM Usernames WHERE username = ?),
-			(SELECT id FROM Channels WHERE channel = ?)
-		)`, username, channel)
-	if err != nil {
-		return fmt.Errorf("failed to follow username '%s' in channel '%s': %v", username, channel, err)
+	for _, username := range usernames {
+		_, err = tx.Exec("INSERT OR IGNORE INTO Usernames(username) VALUES (?)", username)
+		if err != nil {
+			return fmt.Errorf("failed to add username '%s': %v", username, err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO Follows(username_id, channel_id)
+			VALUES (
+				(SELECT id FROM Usernames WHERE username = ?),
+				(SELECT id FROM Channels WHERE channel = ?)
+			)`, username, channel)
+		if err != nil {
+			return fmt.Errorf("failed to follow username '%s' in channel '%s': %v", username, channel, err)
+		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 func (db *DB) Unfollow(username, channel string) error {
+	return db.UnfollowMany([]string{username}, channel)
+}
+
+// UnfollowMany unfollows all of usernames in channel in a single transaction.
+func (db *DB) UnfollowMany(usernames []string, channel string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	_, err := db.db.Exec(`DELETE FROM Follows WHERE
-		username_id = (SELECT id FROM Usernames WHERE username = ?)
-		and
-		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
-
+	tx, err := db.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to unfollow username '%s' in channel '%s': %v", username, channel, err)
+		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	for _, username := range usernames {
+		_, err = tx.Exec(`DELETE FROM Follows WHERE
+			username_id = (SELECT id FROM Usernames WHERE username = ?)
+			and
+			channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
+
+		if err != nil {
+			return fmt.Errorf("failed to unfollow username '%s' in channel '%s': %v", username, channel, err)
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (db *DB) Following(channel string) ([]string, error) {
@@ -212,15 +283,17 @@ func (db *DB) FollowExists(username, channel string) (bool, error) {
 	return exists, err
 }
 
+// GetFollows returns the follows to poll, which leaves out disabled ones.
 func (db *DB) GetFollows() (Users, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
 	follows := Users{}
 
-	rows, err := db.db.Query(`SELECT u.username, c.channel, f.history
+	rows, err := db.db.Query(`SELECT u.username, c.channel, f.seeded, f.lists_seeded
 		FROM Follows f INNER JOIN Usernames u INNER JOIN Channels c
-		ON f.username_id = u.id and f.channel_id = c.id`)
+		ON f.username_id = u.id and f.channel_id = c.id
+		WHERE f.disabled_reason = ''`)
 	if err != nil {
 		return follows, fmt.Errorf("failed to get list of follows: %v", err)
 	}
@@ -228,44 +301,755 @@ func (db *DB) GetFollows() (Users, error) {
 
 	for rows.Next() {
 		var username string
-		var channel string
-		var history string
-		if err := rows.Scan(&username, &channel, &history); err != nil {
+		var follow Follow
+		if err := rows.Scan(&username, &follow.Channel, &follow.Seeded, &follow.ListsSeeded); err != nil {
 			return nil, err
 		}
+		follows[username] = append(follows[username], follow)
+	}
 
-		// if history is an empty string then Split returns []string{""},
-		// instead of an empty slice
-		var hist []string
-		if history == "" {
-			hist = []string{}
-		} else {
-			hist = strings.Split(history, ",")
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return follows, nil
+}
+
+// ChannelFollows are the usernames followed in a channel, as exported and
+// imported.
+type ChannelFollows struct {
+	Channel   string
+	Usernames []string
+	// Settings replace those of the channel on import, nil keeps them
+	Settings *ChannelSettings
+	// History seeds the new follows on import, by username
+	History map[string][]string
+}
+
+// GuildFollows returns the usernames followed in every channel of guild, both
+// sorted. Settings are left nil.
+func (db *DB) GuildFollows(guild string) ([]ChannelFollows, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var channels []ChannelFollows
+
+	rows, err := db.db.Query(`SELECT c.channel, u.username
+		FROM Follows f INNER JOIN Usernames u INNER JOIN Channels c INNER JOIN Guilds g
+		ON f.username_id = u.id and f.channel_id = c.id and c.guild_id = g.id
+		WHERE g.guild = ?
+		ORDER BY c.channel, u.username`, guild)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get follows of guild '%s': %v", guild, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channel, username string
+		if err := rows.Scan(&channel, &username); err != nil {
+			return nil, err
 		}
 
-		follow := Follow{channel, hist}
-		follows[username] = append(follows[username], follow)
+		if len(channels) == 0 || channels[len(channels)-1].Channel != channel {
+			channels = append(channels, ChannelFollows{Channel: channel})
+		}
+		last := &channels[len(channels)-1]
+		last.Usernames = append(last.Usernames, username)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return follows, nil
+	return channels, nil
+}
+
+// ImportFollows adds the follows and settings of channels to guild in a single
+// transaction. None of the usernames may be followed in their channel yet, and
+// each new follow is seeded with its history. Settings only apply to channels
+// that end up following someone.
+func (db *DB) ImportFollows(guild string, channels []ChannelFollows) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, c := range channels {
+		if len(c.Usernames) > 0 {
+			if err := followMany(tx, c.Usernames, c.Channel, guild); err != nil {
+				return err
+			}
+		}
+
+		for _, username := range c.Usernames {
+			if err := seed(tx, username, c.Channel, c.History[username]); err != nil {
+				return err
+			}
+		}
+
+		if c.Settings != nil {
+			if err := setChannelSettings(tx, c.Channel, *c.Settings); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MoveFollows moves every follow of channel from to channel to in guild, with
+// its history so that nothing gets posted again. Users that to already follows
+// keep their follow there and are returned as skipped. The settings of from
+// move along, unless to has its own. Moved follows start posting again, since
+// their failures to post were about the old channel.
+func (db *DB) MoveFollows(from, to, guild string) (moved, skipped []string, err error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT u.username, EXISTS (
+			SELECT 1 FROM Follows t INNER JOIN Channels tc
+			ON t.channel_id = tc.id
+			WHERE t.username_id = f.username_id and tc.channel = ?)
+		FROM Follows f INNER JOIN Usernames u INNER JOIN Channels c
+		ON f.username_id = u.id and f.channel_id = c.id
+		WHERE c.channel = ?
+		ORDER BY u.username`, to, from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get follows of channel '%s': %v", from, err)
+	}
+
+	for rows.Next() {
+		var username string
+		var exists bool
+		if err := rows.Scan(&username, &exists); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+
+		if exists {
+			skipped = append(skipped, username)
+		} else {
+			moved = append(moved, username)
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(moved) == 0 && len(skipped) == 0 {
+		return nil, nil, nil
+	}
+
+	// Following nobody only adds the channel
+	if err := followMany(tx, nil, to, guild); err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.Exec(`UPDATE ChannelSettings SET channel_id = (SELECT id FROM Channels WHERE channel = ?)
+		WHERE channel_id = (SELECT id FROM Channels WHERE channel = ?)
+		and NOT EXISTS (SELECT 1 FROM ChannelSettings s INNER JOIN Channels c ON s.channel_id = c.id WHERE c.channel = ?)`, to, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to move settings of channel '%s' to channel '%s': %v", from, to, err)
+	}
+
+	_, err = tx.Exec(`UPDATE Follows SET channel_id = (SELECT id FROM Channels WHERE channel = ?), send_failures = 0, disabled_reason = ''
+		WHERE channel_id = (SELECT id FROM Channels WHERE channel = ?)
+		and username_id NOT IN (SELECT t.username_id FROM Follows t INNER JOIN Channels tc ON t.channel_id = tc.id WHERE tc.channel = ?)`, to, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to move follows of channel '%s' to channel '%s': %v", from, to, err)
+	}
+
+	// What is left are the skipped follows, removing them lets the triggers
+	// clean up the channel
+	_, err = tx.Exec(`DELETE FROM Follows WHERE channel_id = (SELECT id FROM Channels WHERE channel = ?)`, from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to remove follows of channel '%s': %v", from, err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM Channels WHERE channel = ?`, from)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to remove channel '%s': %v", from, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return moved, skipped, nil
+}
+
+// MarkPosted records the given feed entries as seen by a follow, entries that
+// were already recorded keep their original time.
+func (db *DB) MarkPosted(username, channel string, guids []string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := markPosted(tx, username, channel, guids); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Seed records the given feed entries as seen by a new follow and marks it
+// seeded, so that every entry after them gets posted, even when there were
+// none.
+func (db *DB) Seed(username, channel string, guids []string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := seed(tx, username, channel, guids); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func seed(tx *sql.Tx, username, channel string, guids []string) error {
+	if err := markPosted(tx, username, channel, guids); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE Follows SET seeded = 1, lists_seeded = 1 WHERE
+		username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
+	if err != nil {
+		return fmt.Errorf("failed to seed username '%s' in channel '%s': %v", username, channel, err)
+	}
+
+	return nil
 }
 
-func (db *DB) UpdateHistory(username, channel string, history []string) error {
+// SeedLists records the given lists as seen by a follow from before lists
+// were posted, so that only lists after them get posted.
+func (db *DB) SeedLists(username, channel string, guids []string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	_, err := db.db.Exec(`UPDATE Follows SET history = ? WHERE
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := markPosted(tx, username, channel, guids); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE Follows SET lists_seeded = 1 WHERE
 		username_id = (SELECT id FROM Usernames WHERE username = ?)
 		and
-		channel_id = (SELECT id FROM Channels WHERE channel = ?)`,
-		strings.Join(history, ","),
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
+	if err != nil {
+		return fmt.Errorf("failed to seed lists of username '%s' in channel '%s': %v", username, channel, err)
+	}
+
+	return tx.Commit()
+}
+
+func markPosted(tx *sql.Tx, username, channel string, guids []string) error {
+	stmt, err := tx.Prepare(`INSERT OR IGNORE INTO FollowHistory(follow_id, entry_guid, posted_at)
+		SELECT id, ?, ? FROM Follows WHERE
+		username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	for _, guid := range guids {
+		if _, err := stmt.Exec(guid, now, username, channel); err != nil {
+			return fmt.Errorf("failed to mark entry '%s' posted for username '%s' in channel '%s': %v", guid, username, channel, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) Seen(username, channel, guid string) (bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var seen bool
+
+	row := db.db.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM FollowHistory h INNER JOIN Follows f
+		ON h.follow_id = f.id
+		WHERE
+		f.username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		f.channel_id = (SELECT id FROM Channels WHERE channel = ?)
+		and
+		h.entry_guid = ?
+		LIMIT 1)`, username, channel, guid)
+	err := row.Scan(&seen)
+
+	return seen, err
+}
+
+func (db *DB) GetFeedCache(username string) (FeedCache, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var cache FeedCache
+
+	row := db.db.QueryRow(`SELECT fc.etag, fc.last_modified
+		FROM FeedCache fc INNER JOIN Usernames u
+		ON fc.username_id = u.id
+		WHERE u.username = ?`, username)
+	err := row.Scan(&cache.ETag, &cache.LastModified)
+	if err == sql.ErrNoRows {
+		return FeedCache{}, nil
+	}
+
+	return cache, err
+}
+
+func (db *DB) UpdateFeedCache(username string, cache FeedCache) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`INSERT OR REPLACE INTO FeedCache(username_id, etag, last_modified)
+		SELECT id, ?, ? FROM Usernames WHERE username = ?`,
+		cache.ETag,
+		cache.LastModified,
 		username,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update feed cache of username '%s': %v", username, err)
+	}
+
+	return nil
+}
+
+func (db *DB) RecordFetchFailure(username string, reason string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`INSERT INTO FetchFailures(username_id, failures, last_error, last_failure)
+		SELECT id, 1, ?, ? FROM Usernames WHERE username = ?
+		ON CONFLICT(username_id) DO UPDATE SET
+			failures = failures + 1,
+			last_error = excluded.last_error,
+			last_failure = excluded.last_failure`,
+		reason,
+		time.Now().UTC(),
+		username,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record fetch failure of username '%s': %v", username, err)
+	}
+
+	return nil
+}
+
+func (db *DB) ClearFetchFailures(username string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`DELETE FROM FetchFailures WHERE
+		username_id = (SELECT id FROM Usernames WHERE username = ?)`, username)
+	if err != nil {
+		return fmt.Errorf("failed to clear fetch failures of username '%s': %v", username, err)
+	}
+
+	return nil
+}
+
+func (db *DB) GetFetchFailure(username string) (FetchFailure, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var failure FetchFailure
+	var lastFailure sql.NullTime
+
+	row := db.db.QueryRow(`SELECT ff.failures, ff.last_error, ff.last_failure
+		FROM FetchFailures ff INNER JOIN Usernames u
+		ON ff.username_id = u.id
+		WHERE u.username = ?`, username)
+	err := row.Scan(&failure.Failures, &failure.LastError, &lastFailure)
+	if err == sql.ErrNoRows {
+		return FetchFailure{}, nil
+	}
+	failure.LastFailure = lastFailure.Time
+
+	return failure, err
+}
+
+// RecordSendFailure counts another failure in a row to post for the follow of
+// username in channel, and returns how many there have been.
+func (db *DB) RecordSendFailure(username, channel string) (int, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`UPDATE Follows SET send_failures = send_failures + 1 WHERE
+		username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record send failure of username '%s' in channel '%s': %v", username, channel, err)
+	}
+
+	var failures int
+	row := db.db.QueryRow(`SELECT send_failures FROM Follows WHERE
+		username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
+	if err := row.Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to get send failures of username '%s' in channel '%s': %v", username, channel, err)
+	}
+
+	return failures, nil
+}
+
+func (db *DB) ClearSendFailures(username, channel string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`UPDATE Follows SET send_failures = 0 WHERE send_failures != 0
+		and
+		username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, username, channel)
+	if err != nil {
+		return fmt.Errorf("failed to clear send failures of username '%s' in channel '%s': %v", username, channel, err)
+	}
+
+	return nil
+}
+
+// DisableFollow stops posting for the follow of username in channel until it
+// is resumed, reason says why.
+func (db *DB) DisableFollow(username, channel, reason string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`UPDATE Follows SET disabled_reason = ? WHERE
+		username_id = (SELECT id FROM Usernames WHERE username = ?)
+		and
+		channel_id = (SELECT id FROM Channels WHERE channel = ?)`, reason, username, channel)
+	if err != nil {
+		return fmt.Errorf("failed to disable username '%s' in channel '%s': %v", username, channel, err)
+	}
+
+	return nil
+}
+
+// DisabledFollows returns the usernames with disabled follows in channel,
+// mapped to the reason they were disabled.
+func (db *DB) DisabledFollows(channel string) (map[string]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	disabled := map[string]string{}
+
+	rows, err := db.db.Query(`SELECT u.username, f.disabled_reason
+		FROM Follows f INNER JOIN Usernames u INNER JOIN Channels c
+		ON f.username_id = u.id and f.channel_id = c.id
+		WHERE c.channel = ? and f.disabled_reason != ''`, channel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disabled follows of channel '%s': %v", channel, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var username, reason string
+		if err := rows.Scan(&username, &reason); err != nil {
+			return nil, err
+		}
+		disabled[username] = reason
+	}
+
+	return disabled, rows.Err()
+}
+
+// ResumeFollows enables the disabled follows of channel again, and returns how
+// many there were.
+func (db *DB) ResumeFollows(channel string) (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	result, err := db.db.Exec(`UPDATE Follows SET disabled_reason = '', send_failures = 0
+		WHERE disabled_reason != '' and channel_id = (SELECT id FROM Channels WHERE channel = ?)`, channel)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resume follows of channel '%s': %v", channel, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteChannel removes a channel, the triggers remove its follows and
+// settings with it.
+func (db *DB) DeleteChannel(channel string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec("DELETE FROM Channels WHERE channel = ?", channel)
+	if err != nil {
+		return fmt.Errorf("failed to delete channel '%s': %v", channel, err)
+	}
+
+	return nil
+}
+
+// DeleteGuild removes a guild with its configuration, the triggers remove its
+// channels with it.
+func (db *DB) DeleteGuild(guild string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec("DELETE FROM Guilds WHERE guild = ?", guild)
+	if err != nil {
+		return fmt.Errorf("failed to delete guild '%s': %v", guild, err)
+	}
+
+	return nil
+}
+
+func (db *DB) SetDisplayName(username, displayName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	_, err := db.db.Exec(`INSERT OR REPLACE INTO Profiles(username_id, display_name)
+		SELECT id, ? FROM Usernames WHERE username = ?`, displayName, username)
+	if err != nil {
+		return fmt.Errorf("failed to set display name of username '%s': %v", username, err)
+	}
+
+	return nil
+}
+
+// GetGuildPermissions returns who besides administrators may manage the bot
+// in a guild.
+func (db *DB) GetGuildPermissions(guild string) (GuildPermissions, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var perms GuildPermissions
+
+	row := db.db.QueryRow("SELECT manager_role, manage_channels FROM Guilds WHERE guild = ?", guild)
+	err := row.Scan(&perms.ManagerRole, &perms.ManageChannels)
+	if err == sql.ErrNoRows {
+		return GuildPermissions{}, nil
+	}
+	if err != nil {
+		return GuildPermissions{}, fmt.Errorf("failed to get permissions of guild '%s': %v", guild, err)
+	}
+
+	return perms, nil
+}
+
+func (db *DB) SetGuildPermissions(guild string, perms GuildPermissions) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO Guilds(guild) VALUES (?)", guild)
+	if err != nil {
+		return fmt.Errorf("failed to add guild '%s': %v", guild, err)
+	}
+
+	_, err = tx.Exec("UPDATE Guilds SET manager_role = ?, manage_channels = ? WHERE guild = ?",
+		perms.ManagerRole, perms.ManageChannels, guild)
+	if err != nil {
+		return fmt.Errorf("failed to set permissions of guild '%s': %v", guild, err)
+	}
+
+	return tx.Commit()
+}
+
+// defaultPrefix starts commands in guilds that didn't choose their own.
+const defaultPrefix = "!"
+
+func (db *DB) GetGuildPrefix(guild string) (string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var prefix string
+
+	row := db.db.QueryRow("SELECT prefix FROM Guilds WHERE guild = ?", guild)
+	err := row.Scan(&prefix)
+	if err == sql.ErrNoRows {
+		return defaultPrefix, nil
+	}
+	if err != nil {
+		return defaultPrefix, fmt.Errorf("failed to get prefix of guild '%s': %v", guild, err)
+	}
+
+	return prefix, nil
+}
+
+func (db *DB) SetGuildPrefix(guild, prefix string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT OR IGNORE INTO Guilds(guild) VALUES (?)", guild)
+	if err != nil {
+		return fmt.Errorf("failed to add guild '%s': %v", guild, err)
+	}
+
+	_, err = tx.Exec("UPDATE Guilds SET prefix = ? WHERE guild = ?", prefix, guild)
+	if err != nil {
+		return fmt.Errorf("failed to set prefix of guild '%s': %v", guild, err)
+	}
+
+	return tx.Commit()
+}
+
+// RecordFilms stores the TMDB IDs of the films of entries by username. Entries
+// without one are skipped.
+func (db *DB) RecordFilms(username string, entries []*FeedEntry) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO FilmEntries(entry_guid, username_id, tmdb_id)
+		SELECT ?, id, ? FROM Usernames WHERE username = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range entries {
+		if e.TMDBID == 0 {
+			continue
+		}
+		if _, err := stmt.Exec(e.ID, e.TMDBID, username); err != nil {
+			return fmt.Errorf("failed to record film of entry '%s' for username '%s': %v", e.ID, username, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FilmMembers returns the followed usernames that logged the film with the
+// given TMDB ID, sorted.
+func (db *DB) FilmMembers(tmdbID int) ([]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	rows, err := db.db.Query(`SELECT DISTINCT u.username
+		FROM FilmEntries f INNER JOIN Usernames u
+		ON f.username_id = u.id
+		WHERE f.tmdb_id = ?
+		ORDER BY u.username`, tmdbID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get members of film '%d': %v", tmdbID, err)
+	}
+	defer rows.Close()
+
+	usernames := []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, fmt.Errorf("failed to get members of film '%d': %v", tmdbID, err)
+		}
+		usernames = append(usernames, username)
+	}
+
+	return usernames, rows.Err()
+}
+
+// GetChannelSettings returns the settings of a channel, or the defaults if
+// they were never changed.
+func (db *DB) GetChannelSettings(channel string) (ChannelSettings, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	settings := DefaultChannelSettings()
+
+	row := db.db.QueryRow(`SELECT s.max_entries, s.review_length, s.color, s.show_reviews, s.spoiler_tags, s.format, s.show_lists
+		FROM ChannelSettings s INNER JOIN Channels c
+		ON s.channel_id = c.id
+		WHERE c.channel = ?`, channel)
+	err := row.Scan(&settings.MaxEntries, &settings.ReviewLength, &settings.Color, &settings.ShowReviews, &settings.SpoilerTags, &settings.Format, &settings.ShowLists)
+	if err == sql.ErrNoRows {
+		return DefaultChannelSettings(), nil
+	}
+	if err != nil {
+		return DefaultChannelSettings(), fmt.Errorf("failed to get settings of channel '%s': %v", channel, err)
+	}
+
+	return settings, nil
+}
+
+// SetChannelSettings stores the settings of a channel. Settings only exist for
+// channels that follow someone, they are removed with the last follow.
+func (db *DB) SetChannelSettings(channel string, settings ChannelSettings) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setChannelSettings(tx, channel, settings); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setChannelSettings(tx *sql.Tx, channel string, settings ChannelSettings) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO ChannelSettings(channel_id, max_entries, review_length, color, show_reviews, spoiler_tags, format, show_lists)
+		SELECT id, ?, ?, ?, ?, ?, ?, ? FROM Channels WHERE channel = ?`,
+		settings.MaxEntries,
+		settings.ReviewLength,
+		settings.Color,
+		settings.ShowReviews,
+		settings.SpoilerTags,
+		settings.Format,
+		settings.ShowLists,
 		channel,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to set settings of channel '%s': %v", channel, err)
+	}
 
-	return err
+	return nil
 }
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"fmt"
+	"path/filepath"
 	"sort"
 	"testing"
 )
 
+func openTestDB(t *testing.T) *DB {
+	db, err := OpenSQLDB("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v\n", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
 func TestDB(t *testing.T) {
 	db, err := OpenSQLDB("sqlite3", "test.db")
 	if err != nil {
@@ -56,3 +68,344 @@ func TestDB(t *testing.T) {
 		t.Errorf("list of usernames is wrong, expected [username2] got %v", following)
 	}
 }
+
+func TestFollowMany(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.FollowMany([]string{"username1", "username2"}, "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to follow usernames: %v", err)
+	}
+
+	// Following anyone twice fails the whole batch
+	if err := db.FollowMany([]string{"username3", "username1"}, "channel1", "guild1"); err == nil {
+		t.Error("unique constraint did not work")
+	}
+
+	following, err := db.Following("channel1")
+	if err != nil {
+		t.Fatalf("failed to get list of usernames: %v", err)
+	}
+	sort.Strings(following)
+	if fmt.Sprint(following) != "[username1 username2]" {
+		t.Errorf("expected [username1 username2] got %v", following)
+	}
+
+	if err := db.UnfollowMany([]string{"username1", "username2"}, "channel1"); err != nil {
+		t.Fatalf("failed to unfollow usernames: %v", err)
+	}
+
+	following, err = db.Following("channel1")
+	if err != nil {
+		t.Fatalf("failed to get list of usernames: %v", err)
+	}
+	if len(following) != 0 {
+		t.Errorf("expected no follows left, got %v", following)
+	}
+}
+
+func TestFeedCache(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	cache, err := db.GetFeedCache("username1")
+	if err != nil {
+		t.Fatalf("failed to get feed cache: %v", err)
+	}
+	if cache != (FeedCache{}) {
+		t.Errorf("expected empty feed cache, got %v", cache)
+	}
+
+	expected := FeedCache{`"abc"`, "Thu, 01 Apr 2021 12:00:00 GMT"}
+	if err := db.UpdateFeedCache("username1", expected); err != nil {
+		t.Fatalf("failed to update feed cache: %v", err)
+	}
+
+	cache, err = db.GetFeedCache("username1")
+	if err != nil {
+		t.Fatalf("failed to get feed cache: %v", err)
+	}
+	if cache != expected {
+		t.Errorf("wrong feed cache, expected %v got %v", expected, cache)
+	}
+
+	// Unfollowing the last channel removes the username and its cache
+	if err := db.Unfollow("username1", "channel1"); err != nil {
+		t.Fatalf("failed to unfollow: %v", err)
+	}
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	cache, err = db.GetFeedCache("username1")
+	if err != nil {
+		t.Fatalf("failed to get feed cache: %v", err)
+	}
+	if cache != (FeedCache{}) {
+		t.Errorf("expected feed cache to be cleaned up, got %v", cache)
+	}
+}
+
+func TestFetchFailures(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	for _, reason := range []string{"timeout", "unexpected response status '503'"} {
+		if err := db.RecordFetchFailure("username1", reason); err != nil {
+			t.Fatalf("failed to record fetch failure: %v", err)
+		}
+	}
+
+	failure, err := db.GetFetchFailure("username1")
+	if err != nil {
+		t.Fatalf("failed to get fetch failure: %v", err)
+	}
+	if failure.Failures != 2 || failure.LastError != "unexpected response status '503'" || failure.LastFailure.IsZero() {
+		t.Errorf("fetch failure recorded incorrectly: %+v", failure)
+	}
+
+	if err := db.ClearFetchFailures("username1"); err != nil {
+		t.Fatalf("failed to clear fetch failures: %v", err)
+	}
+
+	failure, err = db.GetFetchFailure("username1")
+	if err != nil {
+		t.Fatalf("failed to get fetch failure: %v", err)
+	}
+	if failure.Failures != 0 {
+		t.Errorf("expected fetch failures to be cleared, got %+v", failure)
+	}
+}
+
+func TestFollowHistory(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if err := db.Follow("username1", "channel2", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	// GUIDs are opaque, commas included
+	if err := db.MarkPosted("username1", "channel1", []string{"guid,1", "guid2"}); err != nil {
+		t.Fatalf("failed to mark entries posted: %v", err)
+	}
+	if err := db.MarkPosted("username1", "channel1", []string{"guid2"}); err != nil {
+		t.Fatalf("failed to mark entries posted twice: %v", err)
+	}
+
+	tests := []struct {
+		channel string
+		guid    string
+		seen    bool
+	}{
+		{"channel1", "guid,1", true},
+		{"channel1", "guid2", true},
+		{"channel1", "guid", false},
+		{"channel2", "guid2", false},
+	}
+
+	for _, test := range tests {
+		seen, err := db.Seen("username1", test.channel, test.guid)
+		if err != nil {
+			t.Fatalf("failed to check history: %v", err)
+		}
+		if seen != test.seen {
+			t.Errorf("entry '%s' in '%s': expected seen %v got %v", test.guid, test.channel, test.seen, seen)
+		}
+	}
+
+	// Seeding with an empty feed still counts
+	if err := db.Seed("username1", "channel2", nil); err != nil {
+		t.Fatalf("failed to seed follow: %v", err)
+	}
+
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	for _, f := range users["username1"] {
+		if f.Seeded != (f.Channel == "channel2") {
+			t.Errorf("wrong seeded state for follow in '%s': %v", f.Channel, f.Seeded)
+		}
+	}
+
+	// Unfollowing removes the history, following again starts fresh
+	if err := db.Unfollow("username1", "channel1"); err != nil {
+		t.Fatalf("failed to unfollow: %v", err)
+	}
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	seen, err := db.Seen("username1", "channel1", "guid2")
+	if err != nil {
+		t.Fatalf("failed to check history: %v", err)
+	}
+	if seen {
+		t.Error("history was not removed with the follow")
+	}
+}
+
+func TestDeleteChannelAndGuild(t *testing.T) {
+	db := openTestDB(t)
+
+	for _, f := range []struct{ username, channel, guild string }{
+		{"username1", "channel1", "guild1"},
+		{"username2", "channel1", "guild1"},
+		{"username2", "channel2", "guild1"},
+		{"username3", "channel3", "guild2"},
+	} {
+		if err := db.Follow(f.username, f.channel, f.guild); err != nil {
+			t.Fatalf("failed to insert test follow values: %v", err)
+		}
+	}
+	if err := db.MarkPosted("username2", "channel1", []string{"guid1"}); err != nil {
+		t.Fatalf("failed to mark entries posted: %v", err)
+	}
+	if err := db.SetChannelSettings("channel1", ChannelSettings{MaxEntries: 2, ReviewLength: 100, Format: "compact"}); err != nil {
+		t.Fatalf("failed to set channel settings: %v", err)
+	}
+	if err := db.SetGuildPrefix("guild1", "?"); err != nil {
+		t.Fatalf("failed to set prefix: %v", err)
+	}
+
+	if err := db.DeleteChannel("channel1"); err != nil {
+		t.Fatalf("failed to delete channel: %v", err)
+	}
+
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if len(users["username1"]) != 0 || len(users["username2"]) != 1 {
+		t.Errorf("expected only the follows of channel1 to be removed, got %v", users)
+	}
+
+	// Following again starts from scratch
+	if err := db.Follow("username2", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if seen, err := db.Seen("username2", "channel1", "guid1"); err != nil || seen {
+		t.Errorf("history of the deleted channel was kept: %v", err)
+	}
+	if settings, err := db.GetChannelSettings("channel1"); err != nil || settings != DefaultChannelSettings() {
+		t.Errorf("settings of the deleted channel were kept: %+v %v", settings, err)
+	}
+
+	if err := db.DeleteGuild("guild1"); err != nil {
+		t.Fatalf("failed to delete guild: %v", err)
+	}
+
+	users, err = db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if len(users) != 1 || len(users["username3"]) != 1 {
+		t.Errorf("expected only the follows of guild2 to be left, got %v", users)
+	}
+	if prefix, err := db.GetGuildPrefix("guild1"); err != nil || prefix != defaultPrefix {
+		t.Errorf("prefix of the deleted guild was kept: %s %v", prefix, err)
+	}
+}
+
+func TestFilmMembers(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if err := db.Follow("username2", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	if err := db.RecordFilms("username1", []*FeedEntry{{ID: "guid1", TMDBID: 829}, {ID: "guid2"}}); err != nil {
+		t.Fatalf("failed to record films: %v", err)
+	}
+	if err := db.RecordFilms("username2", []*FeedEntry{{ID: "guid3", TMDBID: 829}, {ID: "guid4", TMDBID: 1}}); err != nil {
+		t.Fatalf("failed to record films: %v", err)
+	}
+
+	tests := []struct {
+		tmdbID   int
+		expected []string
+	}{
+		{829, []string{"username1", "username2"}},
+		{1, []string{"username2"}},
+		{0, []string{}},
+	}
+
+	for _, test := range tests {
+		members, err := db.FilmMembers(test.tmdbID)
+		if err != nil {
+			t.Fatalf("failed to get film members: %v", err)
+		}
+		if fmt.Sprint(members) != fmt.Sprint(test.expected) {
+			t.Errorf("film %d: expected %v got %v", test.tmdbID, test.expected, members)
+		}
+	}
+
+	// Films go away with the last follow of a user
+	if err := db.Unfollow("username2", "channel1"); err != nil {
+		t.Fatalf("failed to unfollow: %v", err)
+	}
+
+	members, err := db.FilmMembers(829)
+	if err != nil {
+		t.Fatalf("failed to get film members: %v", err)
+	}
+	if fmt.Sprint(members) != "[username1]" {
+		t.Errorf("expected only username1 after unfollowing, got %v", members)
+	}
+}
+
+func TestChannelSettings(t *testing.T) {
+	db := openTestDB(t)
+
+	settings, err := db.GetChannelSettings("channel1")
+	if err != nil {
+		t.Fatalf("failed to get channel settings: %v", err)
+	}
+	if settings != DefaultChannelSettings() {
+		t.Errorf("expected default settings, got %+v", settings)
+	}
+
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	expected := ChannelSettings{MaxEntries: 2, ReviewLength: 100, Color: 0x123456, ShowReviews: false, Format: "compact"}
+	if err := db.SetChannelSettings("channel1", expected); err != nil {
+		t.Fatalf("failed to set channel settings: %v", err)
+	}
+
+	settings, err = db.GetChannelSettings("channel1")
+	if err != nil {
+		t.Fatalf("failed to get channel settings: %v", err)
+	}
+	if settings != expected {
+		t.Errorf("wrong settings, expected %+v got %+v", expected, settings)
+	}
+
+	// Settings go away with the last follow of the channel
+	if err := db.Unfollow("username1", "channel1"); err != nil {
+		t.Fatalf("failed to unfollow: %v", err)
+	}
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	settings, err = db.GetChannelSettings("channel1")
+	if err != nil {
+		t.Fatalf("failed to get channel settings: %v", err)
+	}
+	if settings != DefaultChannelSettings() {
+		t.Errorf("expected settings to be cleaned up, got %+v", settings)
+	}
+}
@@ -1,63 +1,253 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"sort"
 	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/microcosm-cc/bluemonday"
 )
 
-func CmdFollow(db *DB, args []string, channel, guild string) (string, error) {
-	if len(args) == 0 {
-		return "Usage: `!follow <username>`", nil
+// maxBackfill is the most entries `!follow` posts right away.
+const maxBackfill = 10
+
+// maxBulkFollows is the most usernames `!follow` and `!unfollow` take at once.
+const maxBulkFollows = 50
+
+// messageLimit is the most characters Discord allows in a message.
+const messageLimit = 2000
+
+func CmdFollow(db *DB, c ChatClient, src FeedSource, p *bluemonday.Policy, args []string, channel, guild, prefix string) (string, error) {
+	usage := fmt.Sprintf("Usage: `%sfollow <username>... [--backfill <number>]`, or attach a CSV or text file of usernames", prefix)
+
+	var names []string
+	backfill := 0
+	for i := 0; i < len(args); i++ {
+		if strings.ToLower(args[i]) != "--backfill" {
+			names = append(names, args[i])
+			continue
+		}
+
+		if i+1 == len(args) {
+			return usage, nil
+		}
+
+		n, err := parseIntRange(args[i+1], 1, maxBackfill)
+		if err != nil {
+			return fmt.Sprintf("Can't backfill, %v.", err), nil
+		}
+		backfill = n
+		i++
 	}
 
-	username := strings.ToLower(args[0])
+	usernames := usernameList(names)
+	if len(usernames) == 0 {
+		return usage, nil
+	}
+	if len(usernames) > maxBulkFollows {
+		return fmt.Sprintf("Can't follow more than %d users at once.", maxBulkFollows), nil
+	}
+
+	// Every feed is checked before following anyone, then everyone that
+	// passed is followed at once
+	results := make([]string, len(usernames))
+	feeds := map[string]Feed{}
+	var followed []string
+	var errs []string
+
+	for i, username := range usernames {
+		feed, resp, err := checkFollow(db, src, p, username, channel)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		if resp != "" {
+			results[i] = resp
+			continue
+		}
+
+		feeds[username] = feed
+		followed = append(followed, username)
+	}
+
+	if len(followed) > 0 {
+		if err := db.FollowMany(followed, channel, guild); err != nil {
+			return "", fmt.Errorf("failed to follow usernames %v in channel '%s' in guild '%s': %v\n", followed, channel, guild, err)
+		}
+	}
+
+	var settings ChannelSettings
+	if backfill > 0 && len(followed) > 0 {
+		var err error
+		settings, err = db.GetChannelSettings(channel)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+
+	for i, username := range usernames {
+		feed, ok := feeds[username]
+		if !ok {
+			continue
+		}
+
+		resp, err := seedFollow(db, c, feed, channel, backfill, settings)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		results[i] = resp
+	}
+
+	var err error
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, ""))
+	}
+
+	if len(usernames) == 1 {
+		return results[0], err
+	}
+	return bulkSummary(fmt.Sprintf("Followed %d of %d users in this channel:", len(followed), len(usernames)), results), err
+}
+
+// checkFollow checks that username can be followed in channel, and fetches
+// their feed to seed the follow with. When they can't, resp says why.
+func checkFollow(db *DB, src FeedSource, p *bluemonday.Policy, username, channel string) (feed Feed, resp string, err error) {
+	// The username ends up in the feed URL
+	if !validUsername(username) {
+		return Feed{}, fmt.Sprintf("Invalid username %s, Letterboxd usernames only contain letters, numbers and underscores.", escapeMarkdown(username)), nil
+	}
 
 	exists, err := db.FollowExists(username, channel)
 	if err != nil {
-		return "", fmt.Errorf("failed to check if username '%s' exists in channel '%s': %v\n", username, channel, err)
+		return Feed{}, "Can't check the followed users of this channel right now, please try again later.",
+			fmt.Errorf("failed to check if username '%s' exists in channel '%s': %v\n", username, channel, err)
 	}
 
 	if exists {
-		return fmt.Sprintf("Already following %s in this channel.", username), nil
+		return Feed{}, fmt.Sprintf("Already following %s in this channel.", username), nil
+	}
+
+	return fetchNewFeed(src, p, username)
+}
+
+// fetchNewFeed fetches the feed of a user about to be followed, to check that
+// it exists and to seed the history of the follow with what is already in it.
+// When it can't be followed, resp says why.
+func fetchNewFeed(src FeedSource, p *bluemonday.Policy, username string) (feed Feed, resp string, err error) {
+	body, _, err := src.FetchFeed(context.Background(), username, FeedCache{})
+	if statusErr, ok := err.(*StatusError); ok && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusForbidden) {
+		return Feed{}, fmt.Sprintf("Can't follow %s, the Letterboxd user doesn't exist or their profile is private.", username), nil
+	}
+	if err != nil {
+		return Feed{}, "Can't reach Letterboxd right now, please try again later.", fmt.Errorf("failed to check feed of username '%s': %v\n", username, err)
 	}
+	defer body.Close()
 
-	err = db.Follow(username, channel, guild)
+	feed, err = ParseFeed(body, username, p)
 	if err != nil {
-		return "", fmt.Errorf("failed to follow username '%s' in channel '%s' in guild '%s': %v\n", username, channel, guild, err)
+		return Feed{}, "Can't read the Letterboxd feed of this user right now, please try again later.", fmt.Errorf("failed to parse feed of username '%s': %v\n", username, err)
 	}
 
-	return fmt.Sprintf("Now following %s in this channel.", username), nil
+	return feed, "", nil
 }
 
-func CmdUnfollow(db *DB, args []string, channel string) (string, error) {
-	if len(args) == 0 {
-		return "Usage: `!unfollow <username>`", nil
+// seedFollow finishes a new follow of feed in channel: everything in the feed
+// counts as seen, except the last backfill entries which are posted right away.
+func seedFollow(db *DB, c ChatClient, feed Feed, channel string, backfill int, settings ChannelSettings) (string, error) {
+	username := feed.Username
+
+	if err := db.SetDisplayName(username, feed.DisplayName); err != nil {
+		log.Printf("%v\n", err)
 	}
 
-	username := strings.ToLower(args[0])
+	resp := fmt.Sprintf("Now following %s (%s) in this channel.", feed.DisplayName, username)
 
-	exists, err := db.FollowExists(username, channel)
-	if err != nil {
-		return "", fmt.Errorf("failed to check if username '%s' exists in channel '%s': %v\n", username, channel, err)
+	if backfill > len(feed.Entries) {
+		backfill = len(feed.Entries)
+	}
+	backfilled := feed
+	backfilled.Entries = feed.Entries[:backfill]
+	backfilled.Lists = nil
+	seeded := feed
+	seeded.Entries = feed.Entries[backfill:]
+
+	// An unseeded follow gets seeded by the next poll instead
+	if err := db.Seed(username, channel, seeded.GetHistory()); err != nil {
+		return resp, fmt.Errorf("failed to seed username '%s' in channel '%s': %v\n", username, channel, err)
 	}
 
-	if !exists {
-		return fmt.Sprintf("Can't unfollow %s, username not in the list of followed users in this channel.", username), nil
+	if backfill == 0 {
+		return resp, nil
 	}
 
-	err = db.Unfollow(username, channel)
-	if err != nil {
-		return "", fmt.Errorf("failed to unfollow username '%s' in channel '%s': %v\n", username, channel, err)
+	// Entries that fail to post stay unseen, so the next poll retries them
+	if err := sendEmbeds(c, channel, backfilled.GenerateEmbeds(settings)); err != nil {
+		return resp, nil
+	}
+	if err := db.MarkPosted(username, channel, backfilled.GetHistory()); err != nil {
+		return resp, fmt.Errorf("failed to update history of username '%s' in channel '%s': %v\n", username, channel, err)
+	}
+
+	if backfill == 1 {
+		return fmt.Sprintf("Now following %s (%s) in this channel, posted their last entry.", feed.DisplayName, username), nil
+	}
+	return fmt.Sprintf("Now following %s (%s) in this channel, posted their last %d entries.", feed.DisplayName, username, backfill), nil
+}
+
+func CmdUnfollow(db *DB, args []string, channel, prefix string) (string, error) {
+	usernames := usernameList(args)
+	if len(usernames) == 0 {
+		return fmt.Sprintf("Usage: `%sunfollow <username>...`", prefix), nil
 	}
+	if len(usernames) > maxBulkFollows {
+		return fmt.Sprintf("Can't unfollow more than %d users at once.", maxBulkFollows), nil
+	}
+
+	results := make([]string, len(usernames))
+	var unfollowed []string
 
-	return fmt.Sprintf("%s is no longer being followed in this channel.", username), nil
+	for i, username := range usernames {
+		exists, err := db.FollowExists(username, channel)
+		if err != nil {
+			return "", fmt.Errorf("failed to check if username '%s' exists in channel '%s': %v\n", username, channel, err)
+		}
+
+		if !exists {
+			results[i] = fmt.Sprintf("Can't unfollow %s, username not in the list of followed users in this channel.", username)
+			continue
+		}
+
+		unfollowed = append(unfollowed, username)
+		results[i] = fmt.Sprintf("%s is no longer being followed in this channel.", username)
+	}
+
+	if len(unfollowed) > 0 {
+		if err := db.UnfollowMany(unfollowed, channel); err != nil {
+			return "", fmt.Errorf("failed to unfollow usernames %v in channel '%s': %v\n", unfollowed, channel, err)
+		}
+	}
+
+	if len(usernames) == 1 {
+		return results[0], nil
+	}
+	return bulkSummary(fmt.Sprintf("Unfollowed %d of %d users in this channel:", len(unfollowed), len(usernames)), results), nil
 }
 
-func CmdFollowing(db *DB, channel string) (string, error) {
+// bulkSummary lists the result for every user of a bulk command under title,
+// cut to fit in a message.
+func bulkSummary(title string, results []string) string {
+	lines := []string{title}
+	for _, result := range results {
+		lines = append(lines, "- "+result)
+	}
+	return truncate(strings.Join(lines, "\n"), messageLimit)
+}
+
+func CmdFollowing(db *DB, channel, prefix string) (string, error) {
 	following, err := db.Following(channel)
 	if err != nil {
 		return "", fmt.Errorf("failed to get list of followed users for channel '%s': %v\n", channel, err)
@@ -69,8 +259,89 @@ func CmdFollowing(db *DB, channel string) (string, error) {
 
 	sort.Strings(following)
 	usernames := strings.Join(following, ", ")
+	resp := fmt.Sprintf("Following the following Letterboxd usernames in this channel: %s", usernames)
+
+	disabled, err := db.DisabledFollows(channel)
+	if err != nil {
+		return resp, fmt.Errorf("failed to get disabled follows for channel '%s': %v\n", channel, err)
+	}
+
+	if len(disabled) == 0 {
+		return resp, nil
+	}
 
-	return fmt.Sprintf("Following the following Letterboxd usernames in this channel: %s", usernames), nil
+	lines := []string{resp, fmt.Sprintf("Posting stopped for these users after failing to post repeatedly, use `%sresume` once fixed:", prefix)}
+	for _, username := range following {
+		if reason, ok := disabled[username]; ok {
+			lines = append(lines, fmt.Sprintf("- %s: %s", username, reason))
+		}
+	}
+	return truncate(strings.Join(lines, "\n"), messageLimit), nil
+}
+
+func CmdResume(db *DB, channel string) (string, error) {
+	resumed, err := db.ResumeFollows(channel)
+	if err != nil {
+		return "", fmt.Errorf("failed to resume follows for channel '%s': %v\n", channel, err)
+	}
+
+	switch resumed {
+	case 0:
+		return "Nothing is stopped in this channel.", nil
+	case 1:
+		return "Resumed posting for 1 user in this channel.", nil
+	}
+	return fmt.Sprintf("Resumed posting for %d users in this channel.", resumed), nil
+}
+
+func CmdSettings(db *DB, args []string, channel, prefix string) (string, error) {
+	usage := fmt.Sprintf("Usage: `%ssettings [<name> <value>]`", prefix)
+
+	settings, err := db.GetChannelSettings(channel)
+	if err != nil {
+		return "", fmt.Errorf("failed to get settings for channel '%s': %v\n", channel, err)
+	}
+
+	if len(args) == 0 {
+		lines := []string{"Settings for this channel:"}
+		for _, setting := range channelSettings {
+			lines = append(lines, fmt.Sprintf("**%s**: %s - %s", setting.name, setting.get(settings), setting.description))
+		}
+		lines = append(lines, usage)
+		return strings.Join(lines, "\n"), nil
+	}
+
+	if len(args) < 2 {
+		return usage, nil
+	}
+
+	name := strings.ToLower(args[0])
+	setting, ok := findChannelSetting(name)
+	if !ok {
+		return fmt.Sprintf("Unknown setting %s, use `%ssettings` to see all settings.", name, prefix), nil
+	}
+
+	following, err := db.Following(channel)
+	if err != nil {
+		return "", fmt.Errorf("failed to get list of followed users for channel '%s': %v\n", channel, err)
+	}
+
+	if len(following) == 0 {
+		return "Follow someone in this channel before changing its settings.", nil
+	}
+
+	// Values such as templates may contain spaces
+	value := strings.Join(args[1:], " ")
+	if err := setting.set(&settings, value); err != nil {
+		return fmt.Sprintf("Can't set %s, %v.", name, err), nil
+	}
+
+	err = db.SetChannelSettings(channel, settings)
+	if err != nil {
+		return "", fmt.Errorf("failed to set settings for channel '%s': %v\n", channel, err)
+	}
+
+	return fmt.Sprintf("Set %s to %s in this channel.", name, setting.get(settings)), nil
 }
 
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -79,38 +350,138 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 		return
 	}
 
-	if m.Author.Bot {
+	handleMessage(NewDiscordClient(s), s.State.User.ID, m.Message)
+}
+
+// guildDelete forgets a guild when the bot is removed from it.
+func guildDelete(s *discordgo.Session, g *discordgo.GuildDelete) {
+	// Outages make guilds unavailable without the bot leaving them
+	if g.Unavailable {
 		return
 	}
 
-	if len(m.Content) == 0 {
+	if err := db.DeleteGuild(g.ID); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// channelDelete forgets a channel when it is deleted.
+func channelDelete(s *discordgo.Session, c *discordgo.ChannelDelete) {
+	if err := db.DeleteChannel(c.ID); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// handleMessage runs the command in m, if it starts with the prefix of its
+// guild or mentions the bot with the given user ID.
+func handleMessage(c ChatClient, botID string, m *discordgo.Message) {
+	if m.Author.Bot {
 		return
 	}
 
-	if m.Content[0] != '!' {
+	prefix, err := db.GetGuildPrefix(m.GuildID)
+	if err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	text, ok := commandText(m.Content, prefix, botID)
+	if !ok {
 		return
 	}
 
 	say := func(text string) {
-		s.ChannelMessageSend(m.ChannelID, text)
+		if err := c.SendMessage(m.ChannelID, text); err != nil {
+			log.Printf("failed to send message: %v\n", err)
+		}
 	}
 
-	msg := strings.Fields(m.Content)
+	msg := strings.Fields(text)
+	if len(msg) == 0 {
+		return
+	}
 	cmd := strings.ToLower(msg[0])
 	args := msg[1:]
 
-	var isAdmin bool
-	perms, err := s.State.MessagePermissions(m.Message)
+	// Keep the value of a setting as it was written, templates can span
+	// multiple lines
+	if cmd == "settings" {
+		args = splitArgs(text, 3)[1:]
+	}
+
+	perms, err := c.MessagePermissions(m)
 	if err != nil {
 		log.Printf("failed to get message permissions: %v\n", err)
-		isAdmin = false
-	} else {
-		isAdmin = perms&discordgo.PermissionAdministrator != 0
+		perms = 0
+	}
+
+	var roles []string
+	if m.Member != nil {
+		roles = m.Member.Roles
+	}
+
+	// Tell whoever lacks permission instead of ignoring them
+	switch cmd {
+	case "follow", "unfollow", "settings", "export", "import", "move-follows", "resume":
+		allowed, err := authorize(db, m.GuildID, perms, roles)
+		if err != nil {
+			log.Printf("failed to authorize command: %v\n", err)
+		}
+		if !allowed {
+			say(notAuthorized)
+			return
+		}
+
+	case "permissions", "prefix":
+		if perms&discordgo.PermissionAdministrator == 0 {
+			say(notAdmin)
+			return
+		}
+	}
+
+	// Usernames to follow can also come in uploaded files, imports only
+	// come in them
+	var data []byte
+	switch cmd {
+	case "follow":
+		for _, a := range m.Attachments {
+			usernames, err := readUsernames(importClient, a)
+			if err != nil {
+				say(fmt.Sprintf("Can't import %s, %v.", escapeMarkdown(a.Filename), err))
+				return
+			}
+			args = append(args, usernames...)
+		}
+
+	case "import":
+		if len(m.Attachments) > 0 {
+			a := m.Attachments[0]
+			if !isBackupFile(a.Filename) {
+				say(fmt.Sprintf("Can't import %s, only files made by `%sexport` can be imported.", escapeMarkdown(a.Filename), prefix))
+				return
+			}
+
+			data, err = downloadAttachment(importClient, a)
+			if err != nil {
+				say(fmt.Sprintf("Can't import %s, %v.", escapeMarkdown(a.Filename), err))
+				return
+			}
+		}
 	}
 
 	switch {
-	case cmd == "!follow" && isAdmin:
-		resp, err := CmdFollow(db, args, m.ChannelID, m.GuildID)
+	case cmd == "export":
+		resp, err := CmdExport(db, c, m.ChannelID, m.GuildID, prefix)
+
+		if err != nil {
+			log.Printf("failed to execute CmdExport: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
+
+	case cmd == "follow":
+		resp, err := CmdFollow(db, c, feedSource, feedPolicy, args, m.ChannelID, m.GuildID, prefix)
 
 		if err != nil {
 			log.Printf("failed to execute CmdFollow: %v\n", err)
@@ -120,8 +491,8 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 			say(resp)
 		}
 
-	case cmd == "!following":
-		resp, err := CmdFollowing(db, m.ChannelID)
+	case cmd == "following":
+		resp, err := CmdFollowing(db, m.ChannelID, prefix)
 
 		if err != nil {
 			log.Printf("failed to execute CmdFollowing: %v\n", err)
@@ -131,15 +502,77 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 			say(resp)
 		}
 
-	case cmd == "!help":
-		help := `**!follow <username>** - follows a user in this channel
-**!unfollow <username>** - unfollows a user in this channel
-**!following** - shows the list of currently followed users in this channel
-**!help** - shows this help message`
-		say(help)
+	case cmd == "help":
+		say(helpText(prefix))
+
+	case cmd == "import":
+		resp, err := CmdImport(db, c, feedSource, feedPolicy, args, data, m.GuildID, prefix)
+
+		if err != nil {
+			log.Printf("failed to execute CmdImport: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
+
+	case cmd == "move-follows":
+		resp, err := CmdMoveFollows(db, c, args, m.ChannelID, m.GuildID, prefix)
+
+		if err != nil {
+			log.Printf("failed to execute CmdMoveFollows: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
 
-	case cmd == "!unfollow" && isAdmin:
-		resp, err := CmdUnfollow(db, args, m.ChannelID)
+	case cmd == "permissions":
+		resp, err := CmdPermissions(db, args, m.GuildID, prefix)
+
+		if err != nil {
+			log.Printf("failed to execute CmdPermissions: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
+
+	case cmd == "prefix":
+		resp, err := CmdPrefix(db, args, m.GuildID)
+
+		if err != nil {
+			log.Printf("failed to execute CmdPrefix: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
+
+	case cmd == "resume":
+		resp, err := CmdResume(db, m.ChannelID)
+
+		if err != nil {
+			log.Printf("failed to execute CmdResume: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
+
+	case cmd == "settings":
+		resp, err := CmdSettings(db, args, m.ChannelID, prefix)
+
+		if err != nil {
+			log.Printf("failed to execute CmdSettings: %v\n", err)
+		}
+
+		if resp != "" {
+			say(resp)
+		}
+
+	case cmd == "unfollow":
+		resp, err := CmdUnfollow(db, args, m.ChannelID, prefix)
 
 		if err != nil {
 			log.Printf("failed to execute CmdUnfollow: %v\n", err)
@@ -151,3 +584,65 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 
 	}
 }
+
+// commandText returns text without the prefix or the mention of the bot it
+// starts with, and whether it started with either. Mentions always work, so
+// that a forgotten prefix can be looked up with `@bot help`.
+func commandText(text, prefix, botID string) (string, bool) {
+	for _, mention := range []string{"<@" + botID + ">", "<@!" + botID + ">"} {
+		if strings.HasPrefix(text, mention) {
+			return strings.TrimSpace(text[len(mention):]), true
+		}
+	}
+
+	if prefix != "" && strings.HasPrefix(text, prefix) {
+		return text[len(prefix):], true
+	}
+
+	return "", false
+}
+
+func helpText(prefix string) string {
+	return fmt.Sprintf(`**%[1]sfollow <username>... [--backfill <number>]** - follows users in this channel, optionally posting their last entries. Attach a CSV or text file to follow everyone in it
+**%[1]sunfollow <username>...** - unfollows users in this channel
+**%[1]sfollowing** - shows the list of currently followed users in this channel, and whose posting stopped after repeated failures
+**%[1]sresume** - resumes posting for users whose posting stopped in this channel
+**%[1]smove-follows <#channel>** - moves every follow of this channel to another one, without posting anything again
+**%[1]sexport** - makes a file of the follows and settings of every channel in this server
+**%[1]simport [--dry-run]** - restores the follows and settings in an attached file made by export, or only shows what would change
+**%[1]ssettings [<name> <value>]** - shows or changes the settings of this channel
+**%[1]spermissions [grant|revoke <@role|manage-channels>]** - shows or changes who besides administrators can manage follows and settings
+**%[1]sprefix [<prefix>]** - shows or changes the prefix of commands in this server
+**%[1]shelp** - shows this help message
+
+Commands also work by mentioning the bot instead of the prefix, and as slash commands: **/follow**, **/unfollow**, **/following**, **/resume**, **/move-follows**, **/settings**, **/permissions** and **/prefix**. Export and import need files, so they are only messages.`, prefix)
+}
+
+// maxPrefixLength keeps prefixes short enough to type.
+const maxPrefixLength = 5
+
+func CmdPrefix(db *DB, args []string, guild string) (string, error) {
+	prefix, err := db.GetGuildPrefix(guild)
+	if err != nil {
+		return "", fmt.Errorf("failed to get prefix for guild '%s': %v\n", guild, err)
+	}
+
+	if len(args) == 0 {
+		return fmt.Sprintf("Commands in this server start with `%[1]s`, like `%[1]shelp`.\nUsage: `%[1]sprefix <prefix>`", prefix), nil
+	}
+
+	if len(args) != 1 {
+		return fmt.Sprintf("Usage: `%sprefix <prefix>`", prefix), nil
+	}
+
+	prefix = args[0]
+	if textLength(prefix) > maxPrefixLength || strings.ContainsAny(prefix, "`@#<>") {
+		return fmt.Sprintf("Can't use %s as prefix, it must be at most %d characters without any of `@#<>` or backticks.", escapeMarkdown(prefix), maxPrefixLength), nil
+	}
+
+	if err := db.SetGuildPrefix(guild, prefix); err != nil {
+		return "", fmt.Errorf("failed to set prefix for guild '%s': %v\n", guild, err)
+	}
+
+	return fmt.Sprintf("Commands in this server now start with `%[1]s`, like `%[1]shelp`.", prefix), nil
+}
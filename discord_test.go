@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCmdFollow(t *testing.T) {
+	db := openTestDB(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/testuser/rss/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	tests := []struct {
+		username string
+		expected string
+	}{
+		{"../admin", "Invalid username ../admin, Letterboxd usernames only contain letters, numbers and underscores."},
+		{"jonh", "Can't follow jonh, the Letterboxd user doesn't exist or their profile is private."},
+		{"TestUser", "Now following Test User (testuser) in this channel."},
+		{"testuser", "Already following testuser in this channel."},
+	}
+
+	c := newRecordingClient()
+	for _, test := range tests {
+		resp, err := CmdFollow(db, c, src, feedPolicy, []string{test.username}, "channel1", "guild1", "!")
+		if err != nil {
+			t.Errorf("failed to follow '%s': %v", test.username, err)
+		}
+		if resp != test.expected {
+			t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, test.expected)
+		}
+	}
+
+	following, err := db.Following("channel1")
+	if err != nil {
+		t.Fatalf("failed to get list of usernames: %v", err)
+	}
+	if len(following) != 1 || following[0] != "testuser" {
+		t.Errorf("list of usernames is wrong, expected [testuser] got %v", following)
+	}
+
+	var displayName string
+	row := db.db.QueryRow(`SELECT p.display_name FROM Profiles p INNER JOIN Usernames u
+		ON p.username_id = u.id WHERE u.username = ?`, "testuser")
+	if err := row.Scan(&displayName); err != nil {
+		t.Fatalf("failed to get display name: %v", err)
+	}
+	if displayName != "Test User" {
+		t.Errorf("wrong display name, expected Test User got %s", displayName)
+	}
+
+	// The follow is seeded right away, without posting anything
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if follows := users["testuser"]; len(follows) != 1 || !follows[0].Seeded {
+		t.Errorf("follow was not seeded: %v", follows)
+	}
+	for _, guid := range []string{"letterboxd-review-1", "letterboxd-list-2"} {
+		if seen, err := db.Seen("testuser", "channel1", guid); err != nil || !seen {
+			t.Errorf("entry '%s' was not seeded: %v", guid, err)
+		}
+	}
+	if len(c.embeds["channel1"]) != 0 {
+		t.Errorf("expected nothing to be posted, got %d embeds", len(c.embeds["channel1"]))
+	}
+}
+
+func TestCmdFollowBackfill(t *testing.T) {
+	db := openTestDB(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(diaryFeed("film3", "film2", "film1")))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"testuser", "--backfill"}, "Usage: `!follow <username>... [--backfill <number>]`, or attach a CSV or text file of usernames"},
+		{[]string{"testuser", "--backfill", "0"}, "Can't backfill, 0 is not a number from 1 to 10."},
+		{[]string{"testuser", "--BACKFILL", "2"}, "Now following Test User (testuser) in this channel, posted their last 2 entries."},
+	}
+
+	c := newRecordingClient()
+	for _, test := range tests {
+		resp, err := CmdFollow(db, c, src, feedPolicy, test.args, "channel1", "guild1", "!")
+		if err != nil {
+			t.Errorf("failed to follow with %v: %v", test.args, err)
+		}
+		if resp != test.expected {
+			t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, test.expected)
+		}
+	}
+
+	embeds := c.embeds["channel1"]
+	if len(embeds) != 1 || strings.Count(embeds[0].Description, "**[") != 2 || !strings.Contains(embeds[0].Description, "film3") {
+		t.Errorf("expected the last 2 entries in one embed, got %v", embeds)
+	}
+
+	for _, guid := range []string{"film3", "film2", "film1"} {
+		if seen, err := db.Seen("testuser", "channel1", guid); err != nil || !seen {
+			t.Errorf("entry '%s' was not recorded: %v", guid, err)
+		}
+	}
+
+	// A follow with more to backfill than the feed has posts what is there
+	c = newRecordingClient()
+	resp, err := CmdFollow(db, c, src, feedPolicy, []string{"testuser", "--backfill", "10"}, "channel2", "guild1", "!")
+	if err != nil {
+		t.Errorf("failed to follow: %v", err)
+	}
+	if resp != "Now following Test User (testuser) in this channel, posted their last 3 entries." {
+		t.Errorf("unexpected response: %s", resp)
+	}
+}
+
+func TestCmdFollowBulk(t *testing.T) {
+	db := openTestDB(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user1/rss/" && r.URL.Path != "/user2/rss/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(diaryFeed("film2", "film1")))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	if err := db.Follow("user3", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	c := newRecordingClient()
+	resp, err := CmdFollow(db, c, src, feedPolicy, []string{"user1", "--backfill", "1", "USER2", "user3", "jonh", "user1"}, "channel1", "guild1", "!")
+	if err != nil {
+		t.Errorf("failed to follow: %v", err)
+	}
+
+	expected := "Followed 2 of 4 users in this channel:\n" +
+		"- Now following Test User (user1) in this channel, posted their last entry.\n" +
+		"- Now following Test User (user2) in this channel, posted their last entry.\n" +
+		"- Already following user3 in this channel.\n" +
+		"- Can't follow jonh, the Letterboxd user doesn't exist or their profile is private."
+	if resp != expected {
+		t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, expected)
+	}
+
+	following, err := db.Following("channel1")
+	if err != nil {
+		t.Fatalf("failed to get list of usernames: %v", err)
+	}
+	sort.Strings(following)
+	if fmt.Sprint(following) != "[user1 user2 user3]" {
+		t.Errorf("expected [user1 user2 user3] to be followed, got %v", following)
+	}
+
+	if c.sends["channel1"] != 2 {
+		t.Errorf("expected a backfill message per followed user, got %d", c.sends["channel1"])
+	}
+
+	resp, err = CmdUnfollow(db, []string{"user1", "User3", "jonh"}, "channel1", "!")
+	if err != nil {
+		t.Errorf("failed to unfollow: %v", err)
+	}
+
+	expected = "Unfollowed 2 of 3 users in this channel:\n" +
+		"- user1 is no longer being followed in this channel.\n" +
+		"- user3 is no longer being followed in this channel.\n" +
+		"- Can't unfollow jonh, username not in the list of followed users in this channel."
+	if resp != expected {
+		t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, expected)
+	}
+
+	following, err = db.Following("channel1")
+	if err != nil {
+		t.Fatalf("failed to get list of usernames: %v", err)
+	}
+	if fmt.Sprint(following) != "[user2]" {
+		t.Errorf("expected [user2] to be followed, got %v", following)
+	}
+}
+
+func TestCmdSettings(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"color", "#000000"}, "Follow someone in this channel before changing its settings."},
+		{[]string{"colour", "#000000"}, "Unknown setting colour, use `!settings` to see all settings."},
+		{[]string{"max-entries", "11"}, "Can't set max-entries, 11 is not a number from 1 to 10."},
+		{[]string{"max-entries", "2"}, "Set max-entries to 2 in this channel."},
+		{[]string{"COLOR", "ABCDEF"}, "Set color to #abcdef in this channel."},
+		{[]string{"reviews", "off"}, "Set reviews to off in this channel."},
+		{[]string{}, "Settings for this channel:\n" +
+			"**max-entries**: 2 - maximum number of films per post, 1 to 10\n" +
+			"**review-length**: 300 - number of characters of a review shown before cutting it off, 1 to 1000\n" +
+			"**color**: #abcdef - embed color as a hex code, like #d8b437\n" +
+			"**reviews**: off - whether reviews are shown, on or off\n" +
+			"**spoilers**: hide - how reviews with spoilers are shown, hide or tag to show them behind spoiler tags\n" +
+			"**format**: detailed - how films are laid out, compact, detailed, one-embed-per-film or a custom template\n" +
+			"**lists**: on - whether new lists are posted, on or off\n" +
+			"Usage: `!settings [<name> <value>]`"},
+	}
+
+	for i, test := range tests {
+		// The first test runs before anyone is followed
+		if i == 1 {
+			if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+				t.Fatalf("failed to insert test follow values: %v", err)
+			}
+		}
+
+		resp, err := CmdSettings(db, test.args, "channel1", "!")
+		if err != nil {
+			t.Errorf("failed to execute settings %v: %v", test.args, err)
+		}
+		if resp != test.expected {
+			t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, test.expected)
+		}
+	}
+
+	// Hints use the prefix of the server
+	expected := "Unknown setting colour, use `?settings` to see all settings."
+	if resp, err := CmdSettings(db, []string{"colour", "#000000"}, "channel1", "?"); err != nil || resp != expected {
+		t.Errorf("\nResponse Received: %v\nResponse Expected: %v\nError: %v", resp, expected, err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord's limits on embeds, counted in characters. The total limit applies
+// to all embeds of a message together.
+const (
+	embedAuthorLimit      = 256
+	embedTitleLimit       = 256
+	embedDescriptionLimit = 4096
+	embedFooterLimit      = 2048
+	embedTotalLimit       = 6000
+	embedsPerMessage      = 10
+)
+
+// descriptionLimit returns how long the description of embed may be without
+// exceeding any limit, given the rest of its text.
+func descriptionLimit(embed *discordgo.MessageEmbed) int {
+	limit := embedTotalLimit - (embedLength(embed) - textLength(embed.Description))
+	if limit > embedDescriptionLimit {
+		limit = embedDescriptionLimit
+	}
+	return limit
+}
+
+// embedLength returns how many characters of embed count towards the total
+// limit.
+func embedLength(embed *discordgo.MessageEmbed) int {
+	n := textLength(embed.Title) + textLength(embed.Description)
+	if embed.Author != nil {
+		n += textLength(embed.Author.Name)
+	}
+	if embed.Footer != nil {
+		n += textLength(embed.Footer.Text)
+	}
+	return n
+}
+
+// groupEmbeds splits embeds, in order, into as few messages as Discord's
+// limits allow.
+func groupEmbeds(embeds []*discordgo.MessageEmbed) [][]*discordgo.MessageEmbed {
+	messages := [][]*discordgo.MessageEmbed{}
+	message := []*discordgo.MessageEmbed{}
+	length := 0
+	for _, embed := range embeds {
+		n := embedLength(embed)
+		if len(message) > 0 && (len(message) == embedsPerMessage || length+n > embedTotalLimit) {
+			messages = append(messages, message)
+			message = []*discordgo.MessageEmbed{}
+			length = 0
+		}
+
+		message = append(message, embed)
+		length += n
+	}
+
+	if len(message) > 0 {
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+// textLength returns the length of text the way Discord counts it.
+func textLength(text string) int {
+	return utf8.RuneCountInString(text)
+}
+
+// truncate shortens text to at most n characters, replacing the end with
+// "..." when it had to be cut. Characters are never split.
+func truncate(text string, n int) string {
+	if textLength(text) <= n {
+		return text
+	}
+
+	runes := []rune(text)
+	if n <= 3 {
+		return string(runes[:n])
+	}
+
+	return string(runes[:n-3]) + "..."
+}
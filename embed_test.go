@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text     string
+		n        int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 7, "too ..."},
+		{"ééééé", 4, "é..."},
+		{"★★★★★", 2, "★★"},
+	}
+
+	for _, test := range tests {
+		if got := truncate(test.text, test.n); got != test.expected {
+			t.Errorf("truncate(%q, %d): expected %q got %q", test.text, test.n, test.expected, got)
+		}
+	}
+}
+
+func TestGenerateEmbedsLimits(t *testing.T) {
+	feed := Feed{Username: "testuser", DisplayName: strings.Repeat("名", 300)}
+	for i := 0; i < 10; i++ {
+		feed.Entries = append(feed.Entries, &FeedEntry{
+			ID:     fmt.Sprint(i),
+			Title:  fmt.Sprintf("Film %d", i),
+			Year:   "2000",
+			Review: strings.Repeat("é", 2000),
+		})
+	}
+
+	settings := DefaultChannelSettings()
+	settings.MaxEntries = 10
+	settings.ReviewLength = 1000
+
+	embeds := feed.GenerateEmbeds(settings)
+	if len(embeds) < 3 {
+		t.Fatalf("expected the entries to be split over at least 3 embeds, got %d", len(embeds))
+	}
+
+	next := 0
+	for _, embed := range embeds {
+		if n := textLength(embed.Description); n > embedDescriptionLimit {
+			t.Errorf("description of %d characters exceeds the limit", n)
+		}
+		if n := textLength(embed.Description) + textLength(embed.Author.Name); n > embedTotalLimit {
+			t.Errorf("embed of %d characters exceeds the limit", n)
+		}
+		if !utf8.ValidString(embed.Description) {
+			t.Error("description contains a split character")
+		}
+
+		// Entries stay complete and in order
+		for strings.Contains(embed.Description, fmt.Sprintf("[Film %d (2000)]", next)) {
+			next++
+		}
+	}
+
+	if next != len(feed.Entries) {
+		t.Errorf("only the first %d entries were laid out in order", next)
+	}
+}
+
+func TestGroupEmbeds(t *testing.T) {
+	embed := func(length int) *discordgo.MessageEmbed {
+		return &discordgo.MessageEmbed{Description: strings.Repeat("a", length)}
+	}
+
+	tests := []struct {
+		name     string
+		lengths  []int
+		expected []int
+	}{
+		{"none", nil, []int{}},
+		{"one message", []int{10, 10, 10}, []int{3}},
+		{"at most ten per message", []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, []int{10, 2}},
+		{"total limit", []int{4000, 2000, 1, 4000}, []int{2, 2}},
+	}
+
+	for _, test := range tests {
+		embeds := []*discordgo.MessageEmbed{}
+		for _, length := range test.lengths {
+			embeds = append(embeds, embed(length))
+		}
+
+		messages := groupEmbeds(embeds)
+		got := []int{}
+		next := 0
+		for _, message := range messages {
+			got = append(got, len(message))
+			for _, e := range message {
+				if e != embeds[next] {
+					t.Errorf("%s: embeds out of order", test.name)
+				}
+				next++
+			}
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(test.expected) {
+			t.Errorf("%s: expected messages of %v embeds, got %v", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultBaseDelay  = 2 * time.Second
+	defaultMaxDelay   = 2 * time.Minute
+)
+
+// RetryFeedSource wraps a FeedSource, retrying failed fetches with
+// exponential backoff and jitter. Every attempt, including retries, first
+// takes a token from Limiter so that all workers sharing it stay within the
+// same request rate. A Retry-After from the server pauses Limiter as a whole,
+// since it applies to every request and not just the one that got it.
+type RetryFeedSource struct {
+	Source     FeedSource
+	Limiter    *RateLimiter
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func NewRetryFeedSource(src FeedSource, limiter *RateLimiter) *RetryFeedSource {
+	return &RetryFeedSource{
+		Source:     src,
+		Limiter:    limiter,
+		MaxRetries: defaultMaxRetries,
+		BaseDelay:  defaultBaseDelay,
+		MaxDelay:   defaultMaxDelay,
+	}
+}
+
+func (s *RetryFeedSource) FetchFeed(ctx context.Context, username string, cache FeedCache) (io.ReadCloser, FeedCache, error) {
+	for attempt := 0; ; attempt++ {
+		if s.Limiter != nil {
+			if err := s.Limiter.Wait(ctx); err != nil {
+				return nil, cache, err
+			}
+		}
+
+		body, fresh, err := s.Source.FetchFeed(ctx, username, cache)
+		if statusErr, ok := err.(*StatusError); ok && statusErr.RetryAfter > 0 && s.Limiter != nil {
+			s.Limiter.PauseUntil(time.Now().Add(statusErr.RetryAfter))
+		}
+		if err == nil || err == ErrNotModified || attempt >= s.MaxRetries || !retryable(err) || ctx.Err() != nil {
+			return body, fresh, err
+		}
+
+		// Waiting longer would hold up a worker or a command, the next poll
+		// tries again instead
+		wait := s.delay(attempt, err)
+		if wait > s.MaxDelay {
+			return body, fresh, err
+		}
+
+		if !sleepUntil(ctx, time.Now().Add(wait)) {
+			return nil, cache, ctx.Err()
+		}
+	}
+}
+
+// delay returns how long to wait before retrying the given attempt. The
+// server's Retry-After takes precedence when it asks for a longer wait, which
+// is the only way to exceed MaxDelay.
+func (s *RetryFeedSource) delay(attempt int, err error) time.Duration {
+	backoff := s.BaseDelay << uint(attempt)
+	if backoff <= 0 || backoff > s.MaxDelay {
+		backoff = s.MaxDelay
+	}
+
+	// Random jitter in [backoff/2, backoff) keeps workers from retrying in
+	// lockstep.
+	if half := int64(backoff / 2); half > 0 {
+		backoff = time.Duration(half + rand.Int63n(half))
+	}
+
+	if statusErr, ok := err.(*StatusError); ok && statusErr.RetryAfter > backoff {
+		return statusErr.RetryAfter
+	}
+
+	return backoff
+}
+
+// retryable reports whether err may go away by trying again. Network errors
+// are, as are rate limiting and server errors; other statuses such as 404
+// are final.
+func retryable(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	if !ok {
+		return true
+	}
+
+	return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+}
+
+// RateLimiter is a token bucket allowing burst requests at once and refilling
+// one token every interval. It is safe for concurrent use.
+type RateLimiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	return &RateLimiter{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a token is available and takes it. It returns early with
+// ctx's error if ctx is done first, the token stays taken in that case.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Taking the token up front, possibly going into debt, reserves this
+	// caller's place in line before the lock is released.
+	l.tokens--
+	wait := time.Duration(-l.tokens * float64(l.interval))
+
+	l.lock.Unlock()
+
+	if wait > 0 && !sleepUntil(ctx, now.Add(wait)) {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// PauseUntil holds back every token until t, by going into as much debt as
+// waiting until then takes. Callers already waiting keep their place.
+func (l *RateLimiter) PauseUntil(t time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// The next Wait takes a token and waits until the debt is paid off
+	if debt := 1 - float64(t.Sub(now))/float64(l.interval); t.After(now) && debt < l.tokens {
+		l.tokens = debt
+	}
+}
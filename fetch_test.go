@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryFeedSource(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.URL.Path == "/missing/rss/":
+			http.NotFound(w, r)
+		case r.URL.Path == "/limited/rss/":
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case requests < 3:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(testFeed))
+		}
+	}))
+	defer server.Close()
+
+	httpSrc := NewHTTPFeedSource()
+	httpSrc.BaseURL = server.URL
+	httpSrc.Client = server.Client()
+
+	src := NewRetryFeedSource(httpSrc, NewRateLimiter(time.Millisecond, 1))
+	src.BaseDelay = time.Millisecond
+	src.MaxDelay = 10 * time.Millisecond
+
+	body, _, err := src.FetchFeed(context.Background(), "testuser", FeedCache{})
+	if err != nil {
+		t.Fatalf("expected fetch to succeed after retries: %v", err)
+	}
+	body.Close()
+
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	requests = 0
+	if _, _, err := src.FetchFeed(context.Background(), "missing", FeedCache{}); err == nil {
+		t.Error("expected an error for a missing feed")
+	}
+
+	if requests != 1 {
+		t.Errorf("expected a 404 not to be retried, got %d requests", requests)
+	}
+
+	// Retry-After beyond MaxDelay gives up right away instead of waiting
+	requests = 0
+	start := time.Now()
+	_, _, err = src.FetchFeed(context.Background(), "limited", FeedCache{})
+	if statusErr, ok := err.(*StatusError); !ok || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected the 429 to be returned, got %v", err)
+	}
+	if requests != 1 || time.Since(start) > time.Second {
+		t.Errorf("expected a long Retry-After not to be waited for, got %d requests in %v", requests, time.Since(start))
+	}
+
+	// The other users of the limiter hold back as well
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := src.Limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the limiter to be paused by Retry-After, got %v", err)
+	}
+}
+
+func TestRateLimiterPauseUntil(t *testing.T) {
+	l := NewRateLimiter(time.Millisecond, 5)
+
+	start := time.Now()
+	l.PauseUntil(start.Add(50 * time.Millisecond))
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("failed to wait: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected Wait to hold back until the pause ends, returned after %v", elapsed)
+	}
+
+	// A pause that already ended doesn't take the remaining tokens away
+	l = NewRateLimiter(time.Hour, 2)
+	l.PauseUntil(time.Now().Add(-time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Errorf("expected token %d right away, got %v", i, err)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("120"); d != 2*time.Minute {
+		t.Errorf("expected 2m, got %v", d)
+	}
+
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(date); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expected about 1h, got %v", d)
+	}
+
+	for _, value := range []string{"", "-5", "soon"} {
+		if d := parseRetryAfter(value); d != 0 {
+			t.Errorf("expected 0 for '%s', got %v", value, d)
+		}
+	}
+}
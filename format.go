@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+const defaultFormat = "detailed"
+
+// formatOutputLimit is the most bytes a template may render, as many as the
+// longest description an embed can have takes in UTF-8. Anything after that
+// would be cut off anyway.
+const formatOutputLimit = 4 * embedDescriptionLimit
+
+// formatTimeout is how long a new custom template may take to render the
+// sample entry before it is refused.
+const formatTimeout = time.Second
+
+// EmbedFormat renders the entries of a feed. Template is executed once per
+// entry with an entryData, PerFilm gives every entry an embed of its own
+// instead of combining them. Such embeds carry the film title, poster and
+// watched date themselves, so the template only renders the rest.
+type EmbedFormat struct {
+	Template *template.Template
+	PerFilm  bool
+}
+
+// entryData is what entry templates are executed with.
+type entryData struct {
+	*FeedEntry
+	// Link is the entry URL, falling back to Letterboxd itself
+	Link     string
+	Settings ChannelSettings
+}
+
+var formatFuncs = template.FuncMap{
+	"stars":    stars,
+	"date":     formatDate,
+	"truncate": func(n int, text string) string { return truncate(text, n) },
+	"review":   formatReview,
+	"escape":   escapeMarkdown,
+}
+
+const detailedTemplate = `**[{{.Title}} ({{.Year}})]({{.Link}})**
+{{with date "2006-01-02" .WatchedDate}}**{{.}}**{{end}} {{stars .Rating}}{{if .Liked}} ♥{{end}} {{if .Rewatch}}↺{{end}}
+{{review .}}
+`
+
+const compactTemplate = `**[{{.Title}} ({{.Year}})]({{.Link}})** {{stars .Rating}}{{if .Liked}} ♥{{end}}{{if .Rewatch}} ↺{{end}}
+`
+
+const perFilmTemplate = `{{stars .Rating}}{{if .Liked}} ♥{{end}}{{if .Rewatch}} ↺{{end}}
+{{review .}}`
+
+// presetFormats can be chosen by name instead of writing a template.
+var presetFormats = map[string]EmbedFormat{
+	"detailed":           {Template: mustParseFormat(detailedTemplate)},
+	"compact":            {Template: mustParseFormat(compactTemplate)},
+	"one-embed-per-film": {Template: mustParseFormat(perFilmTemplate), PerFilm: true},
+}
+
+func presetFormatNames() []string {
+	names := []string{}
+	for name := range presetFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Custom templates are parsed once and then reused for every post.
+var (
+	formatCacheLock sync.Mutex
+	formatCache     = map[string]EmbedFormat{}
+)
+
+func mustParseFormat(text string) *template.Template {
+	return template.Must(parseFormat(text))
+}
+
+func parseFormat(text string) (*template.Template, error) {
+	tmpl, err := template.New("entry").Funcs(formatFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anyone allowed to change settings can write a template, which must
+	// not be able to loop for as long as it likes
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err := checkRanges(t.Tree.Root); err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpl, nil
+}
+
+// checkRanges returns an error for the first range in node that isn't over a
+// list of the entry, such as a range over an integer.
+func checkRanges(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkRanges(child); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *parse.RangeNode:
+		if !entryList(n.Pipe) {
+			return fmt.Errorf("can't range over %s, only over lists of the entry", n.Pipe)
+		}
+		return checkBranch(&n.BranchNode)
+
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode)
+
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode)
+	}
+
+	return nil
+}
+
+func checkBranch(n *parse.BranchNode) error {
+	if err := checkRanges(n.List); err != nil {
+		return err
+	}
+	return checkRanges(n.ElseList)
+}
+
+// entryList reports whether pipe is just a field of entryData that holds a
+// list, like a slice or a map.
+func entryList(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok {
+		return false
+	}
+
+	t := reflect.TypeOf(entryData{})
+	for _, name := range field.Ident {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return false
+		}
+		f, ok := t.FieldByName(name)
+		if !ok {
+			return false
+		}
+		t = f.Type
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// LookupFormat returns the preset named format, or parses format as a custom
+// template.
+func LookupFormat(format string) (EmbedFormat, error) {
+	if preset, ok := presetFormats[format]; ok {
+		return preset, nil
+	}
+
+	formatCacheLock.Lock()
+	defer formatCacheLock.Unlock()
+
+	if cached, ok := formatCache[format]; ok {
+		return cached, nil
+	}
+
+	tmpl, err := parseFormat(format)
+	if err != nil {
+		return EmbedFormat{}, err
+	}
+
+	f := EmbedFormat{Template: tmpl}
+	formatCache[format] = f
+	return f, nil
+}
+
+// ValidateFormat checks that format is a preset or a template that renders a
+// sample entry without errors.
+func ValidateFormat(format string) error {
+	f, err := LookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	sample := &FeedEntry{
+		ID:          "letterboxd-review-1",
+		URL:         "https://letterboxd.com/",
+		Title:       "Chinatown",
+		Year:        "1974",
+		Rating:      9,
+		WatchedDate: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+		Review:      "amazing",
+	}
+
+	// A template stuck rendering keeps its goroutine, but not the command
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Render(sample, DefaultChannelSettings())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(formatTimeout):
+		return errors.New("it takes too long to render")
+	}
+}
+
+// errFormatTooLong stops rendering once the output reached formatOutputLimit.
+var errFormatTooLong = errors.New("template output too long")
+
+// limitedWriter writes to w until n bytes were written, and refuses the
+// writes after that.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		l.n = 0
+		return 0, errFormatTooLong
+	}
+	l.n -= len(p)
+	return l.w.Write(p)
+}
+
+// Render executes the template of the format for a single entry. Output past
+// formatOutputLimit is left out.
+func (f EmbedFormat) Render(e *FeedEntry, settings ChannelSettings) (string, error) {
+	var b strings.Builder
+	err := f.Template.Execute(&limitedWriter{&b, formatOutputLimit}, entryData{e, entryLink(e), settings})
+	if err != nil && !errors.Is(err, errFormatTooLong) {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// entryLink returns the URL of an entry, falling back to Letterboxd itself.
+func entryLink(e *FeedEntry) string {
+	if e.URL == "" {
+		return "https://letterboxd.com/"
+	}
+	return e.URL
+}
+
+// stars renders a rating as stars, "" if the entry is unrated.
+func stars(rating Rating) string {
+	return rating.String()
+}
+
+// formatDate is like time.Format but returns "" for the zero time.
+func formatDate(layout string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+// formatReview renders the review of an entry the way the channel settings
+// ask for: hidden, truncated, in a code block or behind spoiler tags.
+func formatReview(d entryData) string {
+	var review string
+	if !d.Settings.ShowReviews {
+		review = ""
+	} else if d.Spoiler && !d.Settings.SpoilerTags {
+		review = "This review may contain spoilers."
+	} else {
+		review = truncate(d.Review, d.Settings.ReviewLength)
+	}
+
+	if review == "" {
+		return ""
+	}
+
+	if d.Spoiler && d.Settings.SpoilerTags {
+		return fmt.Sprintf("||%s||", escapeMarkdown(review))
+	}
+
+	return fmt.Sprintf("```%s```", review)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderPresets(t *testing.T) {
+	entry := &FeedEntry{
+		URL:         "https://letterboxd.com/testuser/film/chinatown/",
+		Title:       "Chinatown",
+		Year:        "1974",
+		Rating:      9,
+		WatchedDate: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC),
+		Rewatch:     true,
+		Liked:       true,
+		Review:      "amazing",
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"detailed", "**[Chinatown (1974)](https://letterboxd.com/testuser/film/chinatown/)**\n**2021-04-01** ★★★★½ ♥ ↺\n```amazing```\n"},
+		{"compact", "**[Chinatown (1974)](https://letterboxd.com/testuser/film/chinatown/)** ★★★★½ ♥ ↺\n"},
+		{"{{.Title}}: {{truncate 5 .Review}}", "Chinatown: am..."},
+	}
+
+	for _, test := range tests {
+		format, err := LookupFormat(test.format)
+		if err != nil {
+			t.Fatalf("failed to look up format %q: %v", test.format, err)
+		}
+
+		got, err := format.Render(entry, DefaultChannelSettings())
+		if err != nil {
+			t.Fatalf("failed to render format %q: %v", test.format, err)
+		}
+		if got != test.expected {
+			t.Errorf("format %q: expected %q got %q", test.format, test.expected, got)
+		}
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		valid  bool
+	}{
+		{"compact", true},
+		{"{{.Title}} {{stars .Rating}}", true},
+		{"{{.Title", false},
+		{"{{.Director}}", false},
+		{"{{unknown .Title}}", false},
+		{"{{range 100000000}}{{range 100000000}}{{end}}{{end}}", false},
+		{"{{range .Title}}{{end}}", false},
+		{"{{with .Settings}}{{range .MaxEntries}}{{end}}{{end}}", false},
+		{`{{define "loop"}}{{range $.Rating}}{{end}}{{end}}{{template "loop" .}}`, false},
+	}
+
+	for _, test := range tests {
+		err := ValidateFormat(test.format)
+		if (err == nil) != test.valid {
+			t.Errorf("format %q: expected valid to be %v, got error %v", test.format, test.valid, err)
+		}
+	}
+}
+
+func TestRenderOutputLimit(t *testing.T) {
+	// Every template doubles the output of the one before it
+	text := `{{define "t0"}}{{.Title}}{{end}}`
+	for i := 1; i <= 16; i++ {
+		text += fmt.Sprintf(`{{define "t%d"}}{{template "t%d" .}}{{template "t%d" .}}{{end}}`, i, i-1, i-1)
+	}
+	text += `{{template "t16" .}}`
+
+	format, err := LookupFormat(text)
+	if err != nil {
+		t.Fatalf("failed to look up format: %v", err)
+	}
+
+	got, err := format.Render(&FeedEntry{Title: "Chinatown"}, DefaultChannelSettings())
+	if err != nil {
+		t.Fatalf("failed to render format: %v", err)
+	}
+	if len(got) > formatOutputLimit || !strings.HasPrefix(got, "ChinatownChinatown") {
+		t.Errorf("expected the output to stop at %d bytes, got %d", formatOutputLimit, len(got))
+	}
+}
+
+func TestGenerateEmbedsPerFilm(t *testing.T) {
+	watched := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+	feed := Feed{
+		Username:    "testuser",
+		DisplayName: "Test User",
+		Entries: []*FeedEntry{
+			{ID: "1", URL: "https://letterboxd.com/testuser/film/1/", Title: "Film 1", Year: "2000", Rating: 8, WatchedDate: watched, Poster: "poster1", Review: "good"},
+			{ID: "2", Title: "Film 2", Year: "2001"},
+		},
+	}
+
+	settings := DefaultChannelSettings()
+	if got := len(feed.GenerateEmbeds(settings)); got != 1 {
+		t.Errorf("expected the detailed format to combine entries in 1 embed, got %d", got)
+	}
+
+	settings.Format = "one-embed-per-film"
+	embeds := feed.GenerateEmbeds(settings)
+	if len(embeds) != 2 {
+		t.Fatalf("expected 2 embeds, got %d", len(embeds))
+	}
+
+	first := embeds[0]
+	if first.Title != "Film 1 (2000)" || first.URL != "https://letterboxd.com/testuser/film/1/" {
+		t.Errorf("wrong title or link: %q %q", first.Title, first.URL)
+	}
+	if first.Thumbnail == nil || first.Thumbnail.URL != "poster1" {
+		t.Errorf("expected poster1 as thumbnail, got %+v", first.Thumbnail)
+	}
+	if first.Footer == nil || first.Footer.Text != "Watched on Thursday April 1, 2021" {
+		t.Errorf("wrong footer: %+v", first.Footer)
+	}
+	if first.Timestamp != "2021-04-01T00:00:00Z" {
+		t.Errorf("wrong timestamp: %q", first.Timestamp)
+	}
+	if first.Description != "★★★★\n```good```" {
+		t.Errorf("wrong description: %q", first.Description)
+	}
+
+	// Nothing to show is left out
+	second := embeds[1]
+	if second.URL != "https://letterboxd.com/" || second.Thumbnail != nil || second.Footer != nil || second.Timestamp != "" {
+		t.Errorf("expected no link, poster or date, got %+v", second)
+	}
+}
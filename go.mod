@@ -3,7 +3,7 @@ module github.com/the-decompiler/fizzboxd
 go 1.16
 
 require (
-	github.com/bwmarrin/discordgo v0.23.2
+	github.com/bwmarrin/discordgo v0.24.0
 	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/microcosm-cc/bluemonday v1.0.7
 	github.com/mmcdole/gofeed v1.1.1
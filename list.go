@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/mmcdole/gofeed"
+)
+
+// Discord shows the images of up to this many embeds sharing a URL together
+// in the first of them.
+const listPreviewPosters = 4
+
+// ListEntry is a list published by a user.
+type ListEntry struct {
+	ID          string
+	URL         string
+	Title       string
+	Description string
+	FilmCount   int
+	Posters     []string
+	Published   time.Time
+}
+
+var (
+	// Only posters hosted by Letterboxd are kept, like diary posters
+	reListPoster = regexp.MustCompile(`<img src="(https://a\.ltrbxd\.com/resized/[^"]+)"[^>]*>`)
+	reListFilms  = regexp.MustCompile(`(?s)<ul>.*?</ul>`)
+	reListFilm   = regexp.MustCompile(`<li[ >]`)
+	reListMore   = regexp.MustCompile(`<p>\.\.\.plus (\d+) more\..*?</p>`)
+)
+
+func isListItem(item *gofeed.Item) bool {
+	return strings.HasPrefix(item.GUID, "letterboxd-list-")
+}
+
+// parseList reads a list item of a feed. Its description holds the list's own
+// description, followed by posters or titles of its first films and a note on
+// how many more it has.
+func parseList(item *gofeed.Item, policy *bluemonday.Policy) *ListEntry {
+	description := item.Description
+
+	posters := []string{}
+	for _, match := range reListPoster.FindAllStringSubmatch(description, -1) {
+		posters = append(posters, match[1])
+	}
+	description = reListPoster.ReplaceAllString(description, " ")
+
+	count := 0
+	for _, films := range reListFilms.FindAllString(description, -1) {
+		count += len(reListFilm.FindAllString(films, -1))
+	}
+	description = reListFilms.ReplaceAllString(description, " ")
+
+	if more := reListMore.FindStringSubmatch(description); len(more) > 0 {
+		n, _ := strconv.Atoi(more[1])
+		count += n
+		description = reListMore.ReplaceAllString(description, " ")
+	}
+
+	var published time.Time
+	if item.PublishedParsed != nil {
+		published = *item.PublishedParsed
+	}
+
+	return &ListEntry{
+		ID:          item.GUID,
+		URL:         item.Link,
+		Title:       item.Title,
+		Description: strings.TrimSpace(html.UnescapeString(policy.Sanitize(description))),
+		FilmCount:   count,
+		Posters:     posters,
+		Published:   published,
+	}
+}
+
+// listEmbeds renders a list as an embed linking to it, followed by embeds
+// that only add more of its posters to the first one.
+func listEmbeds(l *ListEntry, author *discordgo.MessageEmbedAuthor, settings ChannelSettings) []*discordgo.MessageEmbed {
+	link := l.URL
+	if link == "" {
+		link = "https://letterboxd.com/"
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Author: author,
+		Title:  truncate(fmt.Sprintf("New list: %s", l.Title), embedTitleLimit),
+		URL:    link,
+		Color:  settings.Color,
+	}
+
+	if l.FilmCount == 1 {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: "1 film"}
+	} else if l.FilmCount > 1 {
+		embed.Footer = &discordgo.MessageEmbedFooter{Text: fmt.Sprintf("%d films", l.FilmCount)}
+	}
+
+	if !l.Published.IsZero() {
+		embed.Timestamp = l.Published.Format(time.RFC3339)
+	}
+
+	limit := descriptionLimit(embed)
+	if settings.ReviewLength < limit {
+		limit = settings.ReviewLength
+	}
+	embed.Description = truncate(l.Description, limit)
+
+	embeds := []*discordgo.MessageEmbed{embed}
+	for i, poster := range l.Posters {
+		if i == listPreviewPosters {
+			break
+		}
+
+		if i == 0 {
+			embed.Image = &discordgo.MessageEmbedImage{URL: poster}
+			continue
+		}
+
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			URL:   link,
+			Image: &discordgo.MessageEmbedImage{URL: poster},
+		})
+	}
+
+	return embeds
+}
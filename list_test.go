@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/mmcdole/gofeed"
+)
+
+func TestParseList(t *testing.T) {
+	policy := bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
+	published := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	item := &gofeed.Item{
+		GUID:  "letterboxd-list-1",
+		Link:  "https://letterboxd.com/testuser/list/noir/",
+		Title: "Noir",
+		Description: ` <p>Films &amp; shadows.</p> ` +
+			`<p><img src="https://a.ltrbxd.com/resized/chinatown.jpg"/><img src="https://example.com/bad.jpg"/></p> ` +
+			`<ul> <li> <a href="https://letterboxd.com/film/chinatown/">Chinatown</a> </li> <li> <a href="https://letterboxd.com/film/laura/">Laura</a> </li> </ul> ` +
+			`<p>...plus 8 more. View the full list on Letterboxd.</p> `,
+		PublishedParsed: &published,
+	}
+
+	expected := &ListEntry{
+		ID:          "letterboxd-list-1",
+		URL:         "https://letterboxd.com/testuser/list/noir/",
+		Title:       "Noir",
+		Description: "Films & shadows.",
+		FilmCount:   10,
+		Posters:     []string{"https://a.ltrbxd.com/resized/chinatown.jpg"},
+		Published:   published,
+	}
+
+	if got := parseList(item, policy); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v got %+v", expected, got)
+	}
+}
+
+func TestFilterEntriesLists(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, 4, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	feed := Feed{
+		Entries: []*FeedEntry{
+			{ID: "film3", Published: day(5)},
+			{ID: "film2", Published: day(3)},
+			{ID: "film1", Published: day(1)},
+		},
+		Lists: []*ListEntry{
+			{ID: "list3", Published: day(6)},
+			{ID: "list2", Published: day(4)},
+			// Published before the last seen entry, so from before lists
+			// were recorded in the history
+			{ID: "list1", Published: day(2)},
+		},
+	}
+
+	tests := []struct {
+		history []string
+		entries []string
+		lists   []string
+	}{
+		{[]string{"film2"}, []string{"film3"}, []string{"list3", "list2"}},
+		{[]string{"film2", "list2"}, []string{"film3"}, []string{"list3"}},
+		{[]string{"film3", "list3"}, []string{}, []string{}},
+		{[]string{"film1"}, []string{"film3", "film2"}, []string{"list3", "list2"}},
+	}
+
+	for _, test := range tests {
+		history := map[string]bool{}
+		for _, id := range test.history {
+			history[id] = true
+		}
+		seen := func(id string) bool {
+			return history[id]
+		}
+
+		filtered := feed.FilterEntries(seen, 2)
+
+		entries := []string{}
+		for _, e := range filtered.Entries {
+			entries = append(entries, e.ID)
+		}
+		lists := []string{}
+		for _, l := range filtered.Lists {
+			lists = append(lists, l.ID)
+		}
+
+		if fmt.Sprint(entries) != fmt.Sprint(test.entries) || fmt.Sprint(lists) != fmt.Sprint(test.lists) {
+			t.Errorf("history %v: expected %v and %v, got %v and %v", test.history, test.entries, test.lists, entries, lists)
+		}
+	}
+}
+
+func TestListEmbeds(t *testing.T) {
+	list := &ListEntry{
+		URL:       "https://letterboxd.com/testuser/list/noir/",
+		Title:     "Noir",
+		FilmCount: 10,
+		Posters:   []string{"poster1", "poster2", "poster3", "poster4", "poster5"},
+	}
+
+	embeds := listEmbeds(list, nil, DefaultChannelSettings())
+	if len(embeds) != listPreviewPosters {
+		t.Fatalf("expected %d embeds, got %d", listPreviewPosters, len(embeds))
+	}
+
+	if embeds[0].Title != "New list: Noir" || embeds[0].Footer == nil || embeds[0].Footer.Text != "10 films" {
+		t.Errorf("wrong title or footer: %+v", embeds[0])
+	}
+
+	for i, embed := range embeds {
+		if embed.URL != list.URL || embed.Image == nil || embed.Image.URL != list.Posters[i] {
+			t.Errorf("embed %d: expected poster %s linking to the list, got %+v", i, list.Posters[i], embed)
+		}
+	}
+}
+
+func TestPostUserSeedsOldLists(t *testing.T) {
+	lists := []string{"1"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var items strings.Builder
+		for _, id := range lists {
+			fmt.Fprintf(&items, `<item>
+		<title>List %[1]s</title>
+		<link>https://letterboxd.com/testuser/list/list-%[1]s/</link>
+		<guid isPermaLink="false">letterboxd-list-%[1]s</guid>
+		<description><![CDATA[ <p>list %[1]s</p> ]]></description>
+	</item>`, id)
+		}
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0" xmlns:letterboxd="https://letterboxd.com">
+<channel>
+	<title>Letterboxd - Test User</title>
+	%s
+</channel>
+</rss>`, items.String())
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	// A follow seeded before lists were posted, without diary entries to
+	// tell the old lists apart
+	db := openTestDB(t)
+	if err := db.Follow("testuser", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if err := db.Seed("testuser", "channel1", nil); err != nil {
+		t.Fatalf("failed to seed follow: %v", err)
+	}
+	if _, err := db.db.Exec("UPDATE Follows SET lists_seeded = 0"); err != nil {
+		t.Fatalf("failed to reset lists_seeded: %v", err)
+	}
+
+	c := newRecordingClient()
+	poll := func() {
+		users, err := db.GetFollows()
+		if err != nil {
+			t.Fatalf("failed to get follows: %v", err)
+		}
+		PostUser(context.Background(), user{"testuser", users["testuser"]}, db, c, src, feedPolicy)
+	}
+
+	poll()
+	if len(c.embeds["channel1"]) != 0 {
+		t.Errorf("expected old lists not to be posted, got %d embeds", len(c.embeds["channel1"]))
+	}
+	if seen, err := db.Seen("testuser", "channel1", "letterboxd-list-1"); err != nil || !seen {
+		t.Errorf("old list was not recorded in the history: %v", err)
+	}
+
+	lists = []string{"2", "1"}
+	poll()
+	if embeds := c.embeds["channel1"]; len(embeds) != 1 || embeds[0].Title != "New list: List 2" {
+		t.Errorf("expected the new list to be posted, got %v", embeds)
+	}
+}
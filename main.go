@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -12,6 +14,10 @@ import (
 )
 
 var db *DB
+var feedSource FeedSource
+
+// feedPolicy strips the HTML from feed descriptions
+var feedPolicy = bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
 
 func main() {
 	discordToken := os.Getenv("DISCORD_TOKEN")
@@ -19,13 +25,47 @@ func main() {
 		log.Fatalln("No $DISCORD_TOKEN given.")
 	}
 
+	httpSrc := NewHTTPFeedSource()
+	if baseURL := os.Getenv("FEED_BASE_URL"); baseURL != "" {
+		httpSrc.BaseURL = baseURL
+	}
+
+	// Shared by all PostUser workers and commands, at most one request per
+	// second.
+	feedSource = NewRetryFeedSource(httpSrc, NewRateLimiter(time.Second, 1))
+
+	// Opened before connecting so that command handlers never see a nil DB
+	log.Println("Opening DB")
+	var err error
+	db, err = OpenSQLDB("sqlite3", "fizzboxd.db")
+	if err != nil {
+		log.Fatalf("failed to open database: %v\n", err)
+	}
+
 	discord, err := discordgo.New("Bot " + discordToken)
 	if err != nil {
 		log.Fatalf("failed to create Discord session: %v\n", err)
 	}
-	discord.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages | discordgo.IntentsGuildMembers
+
+	// Legacy `!` commands need the privileged message content intent, they
+	// stay enabled until every server has moved to slash commands.
+	legacyCommands := true
+	if v := os.Getenv("LEGACY_COMMANDS"); v != "" {
+		legacyCommands, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid $LEGACY_COMMANDS: %v\n", err)
+		}
+	}
+
+	discord.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMembers
 	discord.State.MaxMessageCount = 100
-	discord.AddHandler(messageCreate)
+	discord.AddHandler(interactionCreate)
+	discord.AddHandler(guildDelete)
+	discord.AddHandler(channelDelete)
+	if legacyCommands {
+		discord.Identify.Intents |= discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+		discord.AddHandler(messageCreate)
+	}
 
 	log.Println("Connecting to Discord")
 	err = discord.Open()
@@ -33,28 +73,40 @@ func main() {
 		log.Fatalf("failed to open discord connection: %v\n", err)
 	}
 
-	log.Println("Opening DB")
-	db, err = OpenSQLDB("sqlite3", "fizzboxd.db")
-	if err != nil {
-		log.Fatalf("failed to open database: %v\n", err)
+	log.Println("Registering slash commands")
+	if err := RegisterSlashCommands(discord); err != nil {
+		log.Fatalf("failed to register slash commands: %v\n", err)
 	}
 
-	p := bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
+	scheduler := NewScheduler(db, NewDiscordClient(discord), feedSource, feedPolicy)
+	scheduler.Interval = envDuration("POLL_INTERVAL", scheduler.Interval)
+	scheduler.Workers = envInt("POLL_WORKERS", scheduler.Workers)
+	scheduler.Jitter = envDuration("POLL_JITTER", scheduler.Jitter)
 
-	go func(db *DB, discord *discordgo.Session, p *bluemonday.Policy) {
-		for {
-			if err := PostFeeds(db, discord, p); err != nil {
-				log.Printf("failed to post feeds: %v\n", err)
-			}
-			time.Sleep(30 * time.Minute)
-		}
-	}(db, discord, p)
+	shutdownTimeout := envDuration("SHUTDOWN_TIMEOUT", time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(stopped)
+	}()
 
 	log.Println("Bot is now running. Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc
 
+	// Let workers finish the users they are posting, so that the history
+	// is recorded before Discord and the DB go away.
+	log.Println("Stopping scheduler")
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		log.Println("Timed out waiting for posting to finish")
+	}
+
 	log.Println("Closing Discord")
 	discord.Close()
 
@@ -63,3 +115,35 @@ func main() {
 
 	log.Println("bye")
 }
+
+// envDuration returns the duration in the environment variable name, or def
+// if it is unset.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid $%s: %q is not a positive duration\n", name, v)
+	}
+
+	return d
+}
+
+// envInt returns the integer in the environment variable name, or def if it
+// is unset.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		log.Fatalf("invalid $%s: %q is not a positive integer\n", name, v)
+	}
+
+	return i
+}
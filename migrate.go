@@ -0,0 +1,251 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A migration upgrades the schema by one version. It runs inside the
+// transaction that also records the new version.
+type migration func(tx *sql.Tx) error
+
+// migrations are applied in order by DB.init, a database is at version N once
+// the first N migrations have been applied. Released migrations must never be
+// changed or reordered, append a new one instead.
+//
+// The first migration only uses CREATE ... IF NOT EXISTS, so databases created
+// before versioning existed are adopted as version 1 without losing data.
+var migrations = []migration{
+	execMigration(schema),
+	migrateFollowHistory,
+	execMigration(channelSettingsSchema),
+	execMigration("ALTER TABLE ChannelSettings ADD COLUMN spoiler_tags BOOLEAN NOT NULL DEFAULT 0"),
+	execMigration("ALTER TABLE ChannelSettings ADD COLUMN format TEXT NOT NULL DEFAULT 'detailed'"),
+	execMigration("ALTER TABLE ChannelSettings ADD COLUMN show_lists BOOLEAN NOT NULL DEFAULT 1"),
+	execMigration(filmEntriesSchema),
+	// Follows with history were seeded before the state was explicit
+	execMigration(`ALTER TABLE Follows ADD COLUMN seeded BOOLEAN NOT NULL DEFAULT 0;
+UPDATE Follows SET seeded = 1 WHERE EXISTS (SELECT 1 FROM FollowHistory h WHERE h.follow_id = Follows.id);`),
+	execMigration(guildPermissionsSchema),
+	execMigration(guildPrefixSchema),
+	execMigration(deletionSchema),
+	// Lists used to be skipped, so none of the old ones are in the history
+	execMigration("ALTER TABLE Follows ADD COLUMN lists_seeded BOOLEAN NOT NULL DEFAULT 0"),
+}
+
+const channelSettingsSchema = `
+CREATE TABLE ChannelSettings (
+	channel_id INTEGER PRIMARY KEY,
+	max_entries INTEGER NOT NULL,
+	review_length INTEGER NOT NULL,
+	color INTEGER NOT NULL,
+	show_reviews BOOLEAN NOT NULL,
+	FOREIGN KEY (channel_id) REFERENCES Channels(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER CleanChannelSettings
+AFTER DELETE ON Channels
+BEGIN
+	DELETE FROM ChannelSettings WHERE channel_id = OLD.id;
+END;
+`
+
+// FilmEntries links the diary entries of users to the TMDB ID of their film,
+// so that entries about the same film can be found across users.
+const filmEntriesSchema = `
+CREATE TABLE FilmEntries (
+	entry_guid TEXT PRIMARY KEY,
+	username_id INTEGER NOT NULL,
+	tmdb_id INTEGER NOT NULL,
+	FOREIGN KEY (username_id) REFERENCES Usernames(id) ON DELETE CASCADE
+);
+
+CREATE INDEX FilmEntriesFilms ON FilmEntries(tmdb_id);
+
+CREATE TRIGGER CleanFilmEntries
+AFTER DELETE ON Usernames
+BEGIN
+	DELETE FROM FilmEntries WHERE username_id = OLD.id;
+END;
+`
+
+// Guilds that configured permissions are kept after their last follow, so
+// that managers can follow again.
+const guildPermissionsSchema = `
+ALTER TABLE Guilds ADD COLUMN manager_role TEXT NOT NULL DEFAULT '';
+ALTER TABLE Guilds ADD COLUMN manage_channels BOOLEAN NOT NULL DEFAULT 0;
+
+DROP TRIGGER CleanGuilds;
+
+CREATE TRIGGER CleanGuilds
+AFTER DELETE ON Channels
+WHEN (SELECT COUNT(*) FROM Channels WHERE guild_id = OLD.guild_id) = 0
+BEGIN
+	DELETE FROM Guilds WHERE id = OLD.guild_id and manager_role = '' and manage_channels = 0;
+END;
+`
+
+const guildPrefixSchema = `
+ALTER TABLE Guilds ADD COLUMN prefix TEXT NOT NULL DEFAULT '!';
+
+DROP TRIGGER CleanGuilds;
+
+CREATE TRIGGER CleanGuilds
+AFTER DELETE ON Channels
+WHEN (SELECT COUNT(*) FROM Channels WHERE guild_id = OLD.guild_id) = 0
+BEGIN
+	DELETE FROM Guilds WHERE id = OLD.guild_id and manager_role = '' and manage_channels = 0 and prefix = '!';
+END;
+`
+
+func execMigration(query string) migration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// migrate brings the database up to the latest version. The caller must hold
+// the write lock.
+func (db *DB) migrate() error {
+	_, err := db.db.Exec(`CREATE TABLE IF NOT EXISTS SchemaVersion (
+		version INTEGER PRIMARY KEY,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema version table: %v", err)
+	}
+
+	version, err := db.schemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		if err := db.applyMigration(version + 1); err != nil {
+			return fmt.Errorf("failed to migrate schema to version %d: %v", version+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) applyMigration(version int) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migrations[version-1](tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO SchemaVersion(version, applied_at) VALUES (?, ?)", version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) schemaVersion() (int, error) {
+	var version int
+
+	row := db.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM SchemaVersion")
+	if err := row.Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %v", err)
+	}
+
+	return version, nil
+}
+
+// migrateFollowHistory moves the comma-joined Follows.history strings into the
+// FollowHistory table. The bundled SQLite can't drop columns, so history is
+// emptied and left unused.
+func migrateFollowHistory(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE FollowHistory (
+	follow_id INTEGER NOT NULL,
+	entry_guid TEXT NOT NULL,
+	posted_at DATETIME NOT NULL,
+	FOREIGN KEY (follow_id) REFERENCES Follows(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX FollowHistoryEntries ON FollowHistory(follow_id, entry_guid);
+
+CREATE TRIGGER CleanFollowHistory
+AFTER DELETE ON Follows
+BEGIN
+	DELETE FROM FollowHistory WHERE follow_id = OLD.id;
+END;
+`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, history FROM Follows WHERE history != ''")
+	if err != nil {
+		return err
+	}
+
+	histories := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var history string
+		if err := rows.Scan(&id, &history); err != nil {
+			rows.Close()
+			return err
+		}
+		histories[id] = history
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// The original posting times are unknown
+	now := time.Now().UTC()
+	for id, history := range histories {
+		for _, guid := range strings.Split(history, ",") {
+			if guid == "" {
+				continue
+			}
+			_, err := tx.Exec(`INSERT OR IGNORE INTO FollowHistory(follow_id, entry_guid, posted_at)
+				VALUES (?, ?, ?)`, id, guid, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec("UPDATE Follows SET history = ''")
+	return err
+}
+
+// Foreign keys aren't enforced, so removing a guild or channel removes what
+// belongs to it with triggers, which in turn set off the existing ones.
+// Follows that keep failing to post get disabled with a reason instead.
+const deletionSchema = `
+ALTER TABLE Follows ADD COLUMN send_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE Follows ADD COLUMN disabled_reason TEXT NOT NULL DEFAULT '';
+
+CREATE TRIGGER CleanGuildChannels
+AFTER DELETE ON Guilds
+BEGIN
+	DELETE FROM Channels WHERE guild_id = OLD.id;
+END;
+
+CREATE TRIGGER CleanChannelFollows
+AFTER DELETE ON Channels
+BEGIN
+	DELETE FROM Follows WHERE channel_id = OLD.id;
+END;
+`
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// baselineSchema is the schema of databases created before migrations were
+// introduced.
+const baselineSchema = `
+PRAGMA foreign_keys;
+
+CREATE TABLE IF NOT EXISTS Usernames (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS Guilds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS Channels (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	channel TEXT NOT NULL UNIQUE,
+	guild_id INTEGER NOT NULL,
+	FOREIGN KEY (guild_id) REFERENCES Guilds(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS Follows (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username_id INTEGER NOT NULL,
+	channel_id INTEGER NOT NULL,
+	history TEXT NOT NULL DEFAULT '',
+	UNIQUE(username_id, channel_id),
+	FOREIGN KEY (username_id) REFERENCES Usernames(id) ON DELETE CASCADE,
+	FOREIGN KEY (channel_id) REFERENCES Channels(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER IF NOT EXISTS CleanGuilds
+AFTER DELETE ON Channels
+WHEN (SELECT COUNT(*) FROM Channels WHERE guild_id = OLD.guild_id) = 0
+BEGIN
+	DELETE FROM Guilds WHERE id = OLD.guild_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS CleanChannels
+AFTER DELETE ON Follows
+WHEN (SELECT COUNT(*) FROM Follows WHERE channel_id = OLD.channel_id) = 0
+BEGIN
+	DELETE FROM Channels WHERE id = OLD.channel_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS CleanUsernames
+AFTER DELETE ON Follows
+WHEN (SELECT COUNT(*) FROM Follows WHERE username_id = OLD.username_id) = 0
+BEGIN
+	DELETE FROM Usernames WHERE id = OLD.username_id;
+END;
+`
+
+func TestMigrateBaseline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.db")
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	if _, err := old.Exec(baselineSchema); err != nil {
+		t.Fatalf("failed to create baseline schema: %v", err)
+	}
+
+	_, err = old.Exec(`INSERT INTO Usernames(username) VALUES ('username1');
+		INSERT INTO Guilds(guild) VALUES ('guild1');
+		INSERT INTO Channels(channel, guild_id) VALUES ('channel1', 1);
+		INSERT INTO Follows(username_id, channel_id, history) VALUES (1, 1, 'guid1,guid2');`)
+	if err != nil {
+		t.Fatalf("failed to insert baseline data: %v", err)
+	}
+	old.Close()
+
+	db, err := OpenSQLDB("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to migrate baseline database: %v", err)
+	}
+	defer db.Close()
+
+	version, err := db.schemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("wrong schema version, expected %d got %d", len(migrations), version)
+	}
+
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+
+	follows := users["username1"]
+	if len(follows) != 1 || follows[0].Channel != "channel1" || !follows[0].Seeded || follows[0].ListsSeeded {
+		t.Errorf("follows were not preserved: %v", users)
+	}
+
+	for _, guid := range []string{"guid1", "guid2"} {
+		seen, err := db.Seen("username1", "channel1", guid)
+		if err != nil {
+			t.Fatalf("failed to check history: %v", err)
+		}
+		if !seen {
+			t.Errorf("history entry '%s' was not migrated", guid)
+		}
+	}
+
+	// Tables added after the baseline must be usable
+	if err := db.UpdateFeedCache("username1", FeedCache{ETag: `"abc"`}); err != nil {
+		t.Errorf("failed to update feed cache: %v", err)
+	}
+}
+
+func TestMigrateIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	for i := 0; i < 2; i++ {
+		db, err := OpenSQLDB("sqlite3", path)
+		if err != nil {
+			t.Fatalf("failed to open database %d times: %v", i+1, err)
+		}
+		db.Close()
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	db := openTestDB(t)
+
+	saved := migrations
+	defer func() { migrations = saved }()
+
+	migrations = append(migrations[:len(migrations):len(migrations)],
+		execMigration("CREATE TABLE Broken (id INTEGER); INSERT INTO Nonexistent VALUES (1);"),
+	)
+
+	if err := db.init(); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+
+	version, err := db.schemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(saved) {
+		t.Errorf("failed migration changed the schema version to %d", version)
+	}
+
+	var exists bool
+	row := db.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'Broken')")
+	if err := row.Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("failed migration was not rolled back")
+	}
+
+	migrations = saved[:len(saved)-1]
+	if err := db.init(); err == nil {
+		t.Error("expected a newer database to be refused")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	notAuthorized = "You aren't allowed to manage follows and settings in this server, an administrator can grant access with the permissions command."
+	notAdmin      = "Only administrators can use this command."
+)
+
+// GuildPermissions lists who besides administrators may manage the follows
+// and settings of a guild.
+type GuildPermissions struct {
+	// ManagerRole is the ID of the role whose members may, "" for none
+	ManagerRole string
+	// ManageChannels lets members with the Manage Channels permission
+	ManageChannels bool
+}
+
+var roleMentionRegexp = regexp.MustCompile(`^<@&(\d+)>$|^(\d+)$`)
+
+// authorize reports whether a member with the given permissions in a channel
+// and roles may manage the follows and settings of guild. Administrators
+// always may.
+func authorize(db *DB, guild string, perms int64, roles []string) (bool, error) {
+	if perms&discordgo.PermissionAdministrator != 0 {
+		return true, nil
+	}
+
+	gp, err := db.GetGuildPermissions(guild)
+	if err != nil {
+		return false, err
+	}
+
+	if gp.ManageChannels && perms&discordgo.PermissionManageChannels != 0 {
+		return true, nil
+	}
+
+	if gp.ManagerRole != "" {
+		for _, role := range roles {
+			if role == gp.ManagerRole {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func CmdPermissions(db *DB, args []string, guild, prefix string) (string, error) {
+	usage := fmt.Sprintf("Usage: `%spermissions [grant|revoke <@role|manage-channels>]`", prefix)
+
+	perms, err := db.GetGuildPermissions(guild)
+	if err != nil {
+		return "", fmt.Errorf("failed to get permissions for guild '%s': %v\n", guild, err)
+	}
+
+	if len(args) == 0 {
+		role := "none"
+		if perms.ManagerRole != "" {
+			role = fmt.Sprintf("<@&%s>", perms.ManagerRole)
+		}
+
+		lines := []string{
+			"Besides administrators, these can manage follows and settings in this server:",
+			fmt.Sprintf("**manager role**: %s", role),
+			fmt.Sprintf("**manage-channels**: %s - members with the Manage Channels permission", formatOnOff(perms.ManageChannels)),
+			usage,
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	if len(args) != 2 {
+		return usage, nil
+	}
+
+	var grant bool
+	switch strings.ToLower(args[0]) {
+	case "grant":
+		grant = true
+	case "revoke":
+		grant = false
+	default:
+		return usage, nil
+	}
+
+	var resp string
+	if strings.ToLower(args[1]) == "manage-channels" {
+		perms.ManageChannels = grant
+		if grant {
+			resp = "Members with the Manage Channels permission can now manage follows and settings in this server."
+		} else {
+			resp = "Members with the Manage Channels permission can no longer manage follows and settings in this server."
+		}
+	} else {
+		match := roleMentionRegexp.FindStringSubmatch(args[1])
+		if match == nil {
+			return fmt.Sprintf("Can't find a role in %s, mention it like @role.", args[1]), nil
+		}
+
+		role := match[1] + match[2]
+		if grant {
+			perms.ManagerRole = role
+			resp = fmt.Sprintf("Members with <@&%s> can now manage follows and settings in this server.", role)
+		} else {
+			if perms.ManagerRole != role {
+				return fmt.Sprintf("<@&%s> is not the manager role of this server.", role), nil
+			}
+			perms.ManagerRole = ""
+			resp = fmt.Sprintf("Members with <@&%s> can no longer manage follows and settings in this server.", role)
+		}
+	}
+
+	if err := db.SetGuildPermissions(guild, perms); err != nil {
+		return "", fmt.Errorf("failed to set permissions for guild '%s': %v\n", guild, err)
+	}
+
+	return resp, nil
+}
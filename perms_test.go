@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestAuthorize(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.SetGuildPermissions("guild1", GuildPermissions{ManagerRole: "role1", ManageChannels: true}); err != nil {
+		t.Fatalf("failed to set guild permissions: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		guild   string
+		perms   int64
+		roles   []string
+		allowed bool
+	}{
+		{"administrator", "guild2", discordgo.PermissionAdministrator, nil, true},
+		{"nobody", "guild1", 0, []string{"role2"}, false},
+		{"manager role", "guild1", 0, []string{"role2", "role1"}, true},
+		{"manage channels", "guild1", discordgo.PermissionManageChannels, nil, true},
+		{"other guild", "guild2", discordgo.PermissionManageChannels, []string{"role1"}, false},
+	}
+
+	for _, test := range tests {
+		allowed, err := authorize(db, test.guild, test.perms, test.roles)
+		if err != nil {
+			t.Fatalf("%s: failed to authorize: %v", test.name, err)
+		}
+		if allowed != test.allowed {
+			t.Errorf("%s: expected allowed %v got %v", test.name, test.allowed, allowed)
+		}
+	}
+}
+
+func TestCmdPermissions(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"grant"}, "Usage: `!permissions [grant|revoke <@role|manage-channels>]`"},
+		{[]string{"grant", "@role"}, "Can't find a role in @role, mention it like @role."},
+		{[]string{"GRANT", "<@&123>"}, "Members with <@&123> can now manage follows and settings in this server."},
+		{[]string{"grant", "manage-channels"}, "Members with the Manage Channels permission can now manage follows and settings in this server."},
+		{[]string{"revoke", "456"}, "<@&456> is not the manager role of this server."},
+		{[]string{}, "Besides administrators, these can manage follows and settings in this server:\n" +
+			"**manager role**: <@&123>\n" +
+			"**manage-channels**: on - members with the Manage Channels permission\n" +
+			"Usage: `!permissions [grant|revoke <@role|manage-channels>]`"},
+		{[]string{"revoke", "123"}, "Members with <@&123> can no longer manage follows and settings in this server."},
+	}
+
+	for _, test := range tests {
+		resp, err := CmdPermissions(db, test.args, "guild1", "!")
+		if err != nil {
+			t.Errorf("failed to run permissions command %v: %v", test.args, err)
+		}
+		if resp != test.expected {
+			t.Errorf("\nResponse Received: %v\nResponse Expected: %v", resp, test.expected)
+		}
+	}
+
+	// Permissions outlive the last follow of the guild
+	if err := db.Follow("username1", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if err := db.Unfollow("username1", "channel1"); err != nil {
+		t.Fatalf("failed to unfollow: %v", err)
+	}
+
+	perms, err := db.GetGuildPermissions("guild1")
+	if err != nil {
+		t.Fatalf("failed to get guild permissions: %v", err)
+	}
+	if perms != (GuildPermissions{ManageChannels: true}) {
+		t.Errorf("permissions were not kept, got %+v", perms)
+	}
+}
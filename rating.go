@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Rating is a Letterboxd rating in half stars, from 1 for ½ to 10 for ★★★★★.
+// The zero value means the entry is unrated.
+type Rating int
+
+const (
+	Unrated   Rating = 0
+	maxRating Rating = 10
+)
+
+// parseRating reads a rating in stars as written in feeds, like "4.5" or "5".
+// Anything that isn't a rating from ½ to 5 stars is Unrated.
+func parseRating(value string) Rating {
+	stars, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(stars) {
+		return Unrated
+	}
+
+	halves := math.Round(stars * 2)
+	if halves < 1 || halves > float64(maxRating) {
+		return Unrated
+	}
+
+	return Rating(halves)
+}
+
+func (r Rating) Rated() bool {
+	return r > Unrated && r <= maxRating
+}
+
+// String renders the rating as stars, "" if unrated.
+func (r Rating) String() string {
+	if !r.Rated() {
+		return ""
+	}
+
+	s := strings.Repeat("★", int(r)/2)
+	if r%2 == 1 {
+		s += "½"
+	}
+	return s
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParseRating(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Rating
+		stars    string
+	}{
+		{"4.5", 9, "★★★★½"},
+		{"5.0", 10, "★★★★★"},
+		{"5", 10, "★★★★★"},
+		{" 3.0 ", 6, "★★★"},
+		{"0.5", 1, "½"},
+		{"", Unrated, ""},
+		{"4", 8, "★★★★"},
+		{"4.", 8, "★★★★"},
+		{"0", Unrated, ""},
+		{"5.5", Unrated, ""},
+		{"-1", Unrated, ""},
+		{"NaN", Unrated, ""},
+		{"Inf", Unrated, ""},
+		{"four", Unrated, ""},
+	}
+
+	for _, test := range tests {
+		rating := parseRating(test.value)
+		if rating != test.expected {
+			t.Errorf("parseRating(%q): expected %d got %d", test.value, test.expected, rating)
+		}
+		if rating.String() != test.stars {
+			t.Errorf("rating %d: expected %q got %q", rating, test.stars, rating.String())
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"html"
+	"io"
 	"log"
+	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
@@ -20,6 +23,8 @@ type Feed struct {
 	DisplayName string
 	IconURL     string
 	Entries     []*FeedEntry
+	Lists       []*ListEntry
+	Cache       FeedCache
 }
 
 type FeedEntry struct {
@@ -27,12 +32,16 @@ type FeedEntry struct {
 	URL         string
 	Title       string
 	Year        string
-	Rating      int
+	Rating      Rating
 	WatchedDate time.Time
 	Rewatch     bool
 	Poster      string
 	Review      string
 	Spoiler     bool
+	Published   time.Time
+	// TMDBID identifies the film on The Movie Database, 0 if unknown
+	TMDBID int
+	Liked  bool
 }
 
 type user struct {
@@ -40,56 +49,198 @@ type user struct {
 	follows  []Follow
 }
 
-func PostFeeds(db *DB, d *discordgo.Session, p *bluemonday.Policy) error {
-	users, err := db.GetFollows()
+// PostUser fetches the feed of a user and posts its new entries to every
+// channel following them. Returns whether the feed had new entries.
+//
+// Cancelling ctx aborts fetching, but once a feed was fetched its entries are
+// always posted and recorded, so that nothing is posted twice after a restart.
+func PostUser(ctx context.Context, u user, db *DB, c ChatClient, src FeedSource, p *bluemonday.Policy) bool {
+	cache, err := db.GetFeedCache(u.username)
 	if err != nil {
-		return err
+		log.Printf("failed to get feed cache for username '%s': %v\n", u.username, err)
 	}
 
-	in := make(chan user)
-	for x := 0; x < 5; x++ {
-		go PostUser(in, db, d, p)
+	// Seeding records everything in the feed, which a 304 wouldn't give
+	for _, f := range u.follows {
+		if !f.Seeded {
+			cache = FeedCache{}
+			break
+		}
 	}
 
-	for username, follows := range users {
-		in <- user{username, follows}
+	feed, err := GetFeed(ctx, src, u.username, cache, p)
+	if err != nil && err != ErrNotModified {
+		log.Printf("failed to get feed for username '%s': %v\n", u.username, err)
+		if err := db.RecordFetchFailure(u.username, err.Error()); err != nil {
+			log.Printf("%v\n", err)
+		}
+		return false
 	}
-	close(in)
 
-	return nil
-}
+	if err := db.ClearFetchFailures(u.username); err != nil {
+		log.Printf("%v\n", err)
+	}
 
-func PostUser(in chan user, db *DB, d *discordgo.Session, p *bluemonday.Policy) {
-	for u := range in {
-		feed, err := GetFeed(u.username, p)
-		if err != nil {
-			log.Printf("failed to get feed for username '%s': %v\n", u.username, err)
-			return
+	if err == ErrNotModified {
+		return false
+	}
+
+	if err := db.RecordFilms(u.username, feed.Entries); err != nil {
+		log.Printf("%v\n", err)
+	}
+
+	// Only remember the feed as seen once every follow got its entries,
+	// otherwise a 304 on the next cycle would skip the failed ones.
+	failed := false
+	changed := false
+
+	// Done this way so that not multiple requests are made to LB for
+	// someone that is being followed in multiple channels.
+	for _, f := range u.follows {
+		if !f.Seeded {
+			// Seeding failed when following, record what is there now
+			// instead of posting it all
+			if err := db.Seed(u.username, f.Channel, feed.GetHistory()); err != nil {
+				log.Printf("failed to seed history: %v\n", err)
+				failed = true
+			}
+			continue
 		}
 
-		// Done this way so that not multiple requests are made to LB for
-		// someone that is being followed in multiple channels.
-		for _, f := range u.follows {
-			filteredFeed := feed.FilterEntries(f.History, 4)
-			if len(filteredFeed.Entries) == 0 {
-				continue
+		// Lists the feed already had when lists started being posted are old
+		if !f.ListsSeeded {
+			var lists []string
+			for _, l := range feed.Lists {
+				lists = append(lists, l.ID)
 			}
-			embed := filteredFeed.GenerateEmbded()
-
-			// To avoid spamming when first following someone
-			if len(f.History) != 0 {
-				if _, err := d.ChannelMessageSendEmbed(f.Channel, embed); err != nil {
-					log.Printf("failed to send embed message '%v': %v\n", *embed, err)
-					continue
-				}
+			if err := db.SeedLists(u.username, f.Channel, lists); err != nil {
+				log.Printf("failed to seed list history: %v\n", err)
+				failed = true
+				continue
 			}
+		}
 
-			if err := db.UpdateHistory(u.username, f.Channel, feed.GetHistory()); err != nil {
-				log.Printf("failed to update history: %v\n", err)
-				continue
+		settings, err := db.GetChannelSettings(f.Channel)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+
+		seen := func(id string) bool {
+			seen, err := db.Seen(u.username, f.Channel, id)
+			if err != nil {
+				// Rather miss an entry than post it twice
+				log.Printf("failed to check history: %v\n", err)
+				return true
 			}
+			return seen
+		}
+
+		filteredFeed := feed.FilterEntries(seen, settings.MaxEntries)
+		if !settings.ShowLists {
+			filteredFeed.Lists = nil
+		}
+		if len(filteredFeed.Entries) == 0 && len(filteredFeed.Lists) == 0 {
+			continue
+		}
+		changed = true
+
+		if err := sendEmbeds(c, f.Channel, filteredFeed.GenerateEmbeds(settings)); err != nil {
+			recordSendFailure(db, u.username, f.Channel, err)
+			failed = true
+			continue
+		}
+
+		if err := db.ClearSendFailures(u.username, f.Channel); err != nil {
+			log.Printf("%v\n", err)
+		}
+
+		if err := db.MarkPosted(u.username, f.Channel, feed.GetHistory()); err != nil {
+			log.Printf("failed to update history: %v\n", err)
+			failed = true
+			continue
+		}
+	}
+
+	if failed {
+		return changed
+	}
+
+	if err := db.UpdateFeedCache(u.username, feed.Cache); err != nil {
+		log.Printf("failed to update feed cache for username '%s': %v\n", u.username, err)
+	}
+
+	return changed
+}
+
+// sendEmbeds sends embeds to channel in as few messages as possible, stopping
+// at the first failure. Returns the failure, or nil when the entries count as
+// posted, which they also do when Discord rejected a message as invalid, as
+// retrying it would fail forever.
+func sendEmbeds(c ChatClient, channel string, embeds []*discordgo.MessageEmbed) error {
+	for _, message := range groupEmbeds(embeds) {
+		err := c.SendEmbeds(channel, message)
+		if restErr, ok := err.(*discordgo.RESTError); ok && restErr.Response != nil && restErr.Response.StatusCode == http.StatusBadRequest {
+			log.Printf("discord rejected embed message with %d embeds in channel '%s', skipping it: %v\n", len(message), channel, err)
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to send embed message with %d embeds in channel '%s': %v\n", len(message), channel, err)
+			return err
 		}
 	}
+	return nil
+}
+
+// maxSendFailures is how many polls in a row a follow may fail to post to a
+// channel that is gone or off-limits before it gets disabled.
+const maxSendFailures = 5
+
+// recordSendFailure counts a failure to post for the follow of username in
+// channel if err means that trying again won't help, and disables the follow
+// once that kept happening.
+func recordSendFailure(db *DB, username, channel string, err error) {
+	reason := sendFailureReason(err)
+	if reason == "" {
+		return
+	}
+
+	failures, err := db.RecordSendFailure(username, channel)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return
+	}
+	if failures < maxSendFailures {
+		return
+	}
+
+	log.Printf("disabling username '%s' in channel '%s' after %d failures to post: %s\n", username, channel, failures, reason)
+	if err := db.DisableFollow(username, channel, reason); err != nil {
+		log.Printf("%v\n", err)
+	}
+}
+
+// sendFailureReason explains an error from Discord about a channel that can't
+// be posted in, or returns "" for any other error.
+func sendFailureReason(err error) string {
+	restErr, ok := err.(*discordgo.RESTError)
+	if !ok || restErr.Response == nil {
+		return ""
+	}
+
+	var reason string
+	switch restErr.Response.StatusCode {
+	case http.StatusNotFound:
+		reason = "the channel can't be found"
+	case http.StatusForbidden:
+		reason = "the bot isn't allowed to post in the channel"
+	default:
+		return ""
+	}
+
+	if restErr.Message != nil && restErr.Message.Message != "" {
+		reason += fmt.Sprintf(" (%s)", restErr.Message.Message)
+	}
+	return reason
 }
 
 func (f *Feed) GetHistory() []string {
@@ -97,118 +248,164 @@ func (f *Feed) GetHistory() []string {
 	for _, e := range f.Entries {
 		history = append(history, e.ID)
 	}
+	for _, l := range f.Lists {
+		history = append(history, l.ID)
+	}
 	return history
 }
 
-// Keep n amount of entries excluding those in the history.
-func (f Feed) FilterEntries(history []string, numOfEntries int) Feed {
+// Keep n amount of entries newer than the first one that was already seen.
+//
+// Lists are kept the same way, but only when they were also published after
+// that entry, so that a list missing from the history isn't posted long after
+// it was made.
+func (f Feed) FilterEntries(seen func(id string) bool, numOfEntries int) Feed {
 	entries := []*FeedEntry{}
-	count := 0
+	var cutoff time.Time
 	for _, e := range f.Entries {
-		if count >= numOfEntries {
+		if seen(e.ID) {
+			cutoff = e.Published
 			break
 		}
-		if stringInSlice(history, e.ID) {
+		if len(entries) >= numOfEntries {
 			break
 		}
 		entries = append(entries, e)
-		count++
+	}
+
+	lists := []*ListEntry{}
+	for _, l := range f.Lists {
+		if len(lists) >= numOfEntries || seen(l.ID) {
+			break
+		}
+		if !cutoff.IsZero() && !l.Published.After(cutoff) {
+			break
+		}
+		lists = append(lists, l)
 	}
 
 	f.Entries = entries
+	f.Lists = lists
 	return f
 }
 
-func (f *Feed) GenerateEmbded() *discordgo.MessageEmbed {
-	description := ""
+// GenerateEmbeds lays the entries out in as few embeds as Discord's size
+// limits and the channel's format allow, in order. Each embed uses the first
+// poster of its entries, unless the format gives every film its own embed.
+// Lists follow the diary entries with embeds of their own.
+func (f *Feed) GenerateEmbeds(settings ChannelSettings) []*discordgo.MessageEmbed {
+	format, err := LookupFormat(settings.Format)
+	if err != nil {
+		log.Printf("invalid format '%s', using the default: %v\n", settings.Format, err)
+		format = presetFormats[defaultFormat]
+	}
+
+	author := &discordgo.MessageEmbedAuthor{
+		URL:     fmt.Sprintf("https://letterboxd.com/%s/films/diary/", f.Username),
+		Name:    truncate(fmt.Sprintf("Recent diary activity from %s", f.DisplayName), embedAuthorLimit),
+		IconURL: f.IconURL,
+	}
+	limit := descriptionLimit(&discordgo.MessageEmbed{Author: author})
+
+	embeds := []*discordgo.MessageEmbed{}
+	var embed *discordgo.MessageEmbed
 	for _, e := range f.Entries {
-		var url string
-		if e.URL == "" {
-			url = "https://letterboxd.com/"
-		} else {
-			url = e.URL
+		description, err := format.Render(e, settings)
+		if err != nil {
+			log.Printf("failed to render entry '%s', using the default format: %v\n", e.ID, err)
+			description, _ = presetFormats[defaultFormat].Render(e, settings)
 		}
 
-		var watchedDate string
-		if e.WatchedDate.IsZero() {
-			watchedDate = ""
-		} else {
-			watchedDate = fmt.Sprintf("**%s**", e.WatchedDate.Format("2006-01-02"))
+		if format.PerFilm {
+			embeds = append(embeds, filmEmbed(e, author, description, settings))
+			continue
 		}
 
-		var rating string
-		if e.Rating == -1 {
-			rating = ""
-		} else {
-			rating = strings.Repeat("★", e.Rating/10)
-			if e.Rating%10 == 5 {
-				rating += "½"
+		description = truncate(description, limit)
+		if embed == nil || textLength(embed.Description)+textLength(description) > limit {
+			embed = &discordgo.MessageEmbed{
+				Author:    author,
+				Color:     settings.Color,
+				Thumbnail: &discordgo.MessageEmbedThumbnail{},
 			}
+			embeds = append(embeds, embed)
 		}
 
-		var rewatch string
-		if e.Rewatch {
-			rewatch = "↺"
-		} else {
-			rewatch = ""
+		embed.Description += description
+		if embed.Thumbnail.URL == "" {
+			embed.Thumbnail.URL = e.Poster
 		}
+	}
 
-		var review string
-		if e.Spoiler {
-			review = "This review may contain spoilers."
-		} else if len(e.Review) > 300 {
-			review = e.Review[:300] + "..."
-		} else {
-			review = e.Review
-		}
+	for _, l := range f.Lists {
+		embeds = append(embeds, listEmbeds(l, author, settings)...)
+	}
 
-		if review != "" {
-			review = fmt.Sprintf("```%s```", html.UnescapeString(review))
-		}
+	return embeds
+}
 
-		description += fmt.Sprintf("**[%s (%s)](%s)**\n", e.Title, e.Year, url)
-		description += fmt.Sprintf("%s %s %s\n", watchedDate, rating, rewatch)
-		description += fmt.Sprintf("%s\n", review)
+// filmEmbed lays out a single entry in an embed of its own, titled with the
+// film and showing its poster and watched date.
+func filmEmbed(e *FeedEntry, author *discordgo.MessageEmbedAuthor, description string, settings ChannelSettings) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Author: author,
+		Title:  truncate(fmt.Sprintf("%s (%s)", e.Title, e.Year), embedTitleLimit),
+		URL:    entryLink(e),
+		Color:  settings.Color,
 	}
 
-	var poster string
-	for _, e := range f.Entries {
-		if e.Poster != "" {
-			poster = e.Poster
-			break
-		}
+	if e.Poster != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: e.Poster}
 	}
 
-	embed := &discordgo.MessageEmbed{
-		Author: &discordgo.MessageEmbedAuthor{
-			URL:     fmt.Sprintf("https://letterboxd.com/%s/films/diary/", f.Username),
-			Name:    fmt.Sprintf("Recent diary activity from %s", f.DisplayName),
-			IconURL: f.IconURL,
-		},
-		Color:       0xd8b437,
-		Description: description,
-		Thumbnail: &discordgo.MessageEmbedThumbnail{
-			URL: poster,
-		},
+	if !e.WatchedDate.IsZero() {
+		embed.Footer = &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Watched on %s", e.WatchedDate.Format("Monday January 2, 2006")),
+		}
+		embed.Timestamp = e.WatchedDate.Format(time.RFC3339)
 	}
 
+	embed.Description = truncate(description, descriptionLimit(embed))
 	return embed
 }
 
-// Fetches a user's RSS feed, returning an array of 50 FeedEntrys with parsed values
-func GetFeed(username string, policy *bluemonday.Policy) (Feed, error) {
+// Fetches a user's RSS feed, returning an array of 50 FeedEntrys with parsed values.
+// Returns ErrNotModified if the feed did not change since cache was recorded.
+func GetFeed(ctx context.Context, src FeedSource, username string, cache FeedCache, policy *bluemonday.Policy) (Feed, error) {
+	body, fresh, err := src.FetchFeed(ctx, username, cache)
+	if err == ErrNotModified {
+		return Feed{}, err
+	}
+	if err != nil {
+		return Feed{}, fmt.Errorf("failed to fetch feed: %v\n", err)
+	}
+	defer body.Close()
+
+	feed, err := ParseFeed(body, username, policy)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	feed.Cache = fresh
+	return feed, nil
+}
+
+// ParseFeed reads the RSS feed of username from r. Entries that can't be
+// parsed are skipped.
+func ParseFeed(r io.Reader, username string, policy *bluemonday.Policy) (Feed, error) {
 	var iconUrl = "https://cdn.discordapp.com/attachments/530814994204590097/794205173358395422/image0.png"
 
 	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL("https://letterboxd.com/" + username + "/rss/")
-
+	feed, err := fp.Parse(r)
 	if err != nil {
-		return Feed{}, fmt.Errorf("failed to fetch feed: %v\n", err)
+		return Feed{}, fmt.Errorf("failed to parse feed: %v\n", err)
 	}
 
 	entries := []*FeedEntry{}
+	lists := []*ListEntry{}
 	for _, item := range feed.Items {
-		if strings.HasPrefix(item.GUID, "letterboxd-list-") {
+		if isListItem(item) {
+			lists = append(lists, parseList(item, policy))
 			continue
 		}
 		entry, err := parseEntry(item, policy)
@@ -223,6 +420,7 @@ func GetFeed(username string, policy *bluemonday.Policy) (Feed, error) {
 		DisplayName: handleDisplayName(feed.Title),
 		IconURL:     iconUrl,
 		Entries:     entries,
+		Lists:       lists,
 	}, nil
 }
 
@@ -234,6 +432,11 @@ func parseEntry(entry *gofeed.Item, policy *bluemonday.Policy) (*FeedEntry, erro
 		return &FeedEntry{}, err
 	}
 
+	var published time.Time
+	if entry.PublishedParsed != nil {
+		published = *entry.PublishedParsed
+	}
+
 	return &FeedEntry{
 		ID:          entry.GUID,
 		URL:         entry.Link,
@@ -245,6 +448,9 @@ func parseEntry(entry *gofeed.Item, policy *bluemonday.Policy) (*FeedEntry, erro
 		Poster:      poster,
 		Review:      review,
 		Spoiler:     spoiler,
+		Published:   published,
+		TMDBID:      handleTMDBID(entry.Extensions["tmdb"]["movieId"]),
+		Liked:       handleMemberLike(entry.Extensions["letterboxd"]["memberLike"]),
 	}, nil
 }
 
@@ -269,22 +475,12 @@ func handleYear(year []ext.Extension) string {
 	return year[0].Value
 }
 
-func handleRating(rating []ext.Extension) int {
+func handleRating(rating []ext.Extension) Rating {
 	if len(rating) == 0 {
-		return -1
-	}
-
-	i, err := strconv.Atoi(string(rating[0].Value[0]))
-	if err != nil {
-		return -1
-	}
-
-	j, err := strconv.Atoi(string(rating[0].Value[2]))
-	if err != nil {
-		return -1
+		return Unrated
 	}
 
-	return (i * 10) + j
+	return parseRating(rating[0].Value)
 }
 
 func handleWatchedDate(date []ext.Extension) time.Time {
@@ -312,6 +508,26 @@ func handleRewatch(rewatch []ext.Extension) bool {
 	return false
 }
 
+func handleTMDBID(id []ext.Extension) int {
+	if len(id) == 0 {
+		return 0
+	}
+
+	i, err := strconv.Atoi(id[0].Value)
+	if err != nil || i < 0 {
+		return 0
+	}
+	return i
+}
+
+func handleMemberLike(like []ext.Extension) bool {
+	if len(like) == 0 {
+		return false
+	}
+
+	return like[0].Value == "Yes"
+}
+
 func HandleData(title, description string, watchedDate time.Time, policy *bluemonday.Policy) (poster, review string, spoiler bool, err error) {
 	watchedDateString := watchedDate.Format("Monday January 2, 2006")
 
@@ -339,7 +555,7 @@ func HandleData(title, description string, watchedDate time.Time, policy *bluemo
 		review = ""
 		spoiler = false
 	} else {
-		review = policy.Sanitize(description)
+		review = html.UnescapeString(policy.Sanitize(description))
 	}
 	review = strings.TrimSpace(review)
 
@@ -0,0 +1,16 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"testing"
+)
+
+func FuzzParseEntry(f *testing.F) {
+	for _, seed := range parseEntrySeeds {
+		f.Add(seed[0], seed[1], seed[2], seed[3], seed[4], seed[5], seed[6], seed[7])
+	}
+
+	f.Fuzz(checkParseEntry)
+}
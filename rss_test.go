@@ -1,12 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/bwmarrin/discordgo"
 	"github.com/microcosm-cc/bluemonday"
+	"github.com/mmcdole/gofeed"
+	ext "github.com/mmcdole/gofeed/extensions"
 )
 
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
 type Data struct {
 	Title       string
 	Description string
@@ -78,3 +94,429 @@ func TestHandleData(t *testing.T) {
 		}
 	}
 }
+
+// diaryFeed returns a feed of diary entries with the given GUIDs, newest first.
+func diaryFeed(guids ...string) string {
+	var items strings.Builder
+	for _, guid := range guids {
+		fmt.Fprintf(&items, `<item>
+		<title>%[1]s, 2000</title>
+		<link>https://letterboxd.com/testuser/film/%[1]s/</link>
+		<guid isPermaLink="false">%[1]s</guid>
+		<description><![CDATA[ <p>review of %[1]s</p> ]]></description>
+		<letterboxd:filmTitle>%[1]s</letterboxd:filmTitle>
+		<letterboxd:filmYear>2000</letterboxd:filmYear>
+	</item>`, guid)
+	}
+
+	return `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0" xmlns:letterboxd="https://letterboxd.com">
+<channel>
+	<title>Letterboxd - Test User</title>
+	` + items.String() + `
+</channel>
+</rss>`
+}
+
+func TestPostUser(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(diaryFeed("film6", "film5", "film4", "film3", "film2", "film1")))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	policy := bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
+
+	tests := []struct {
+		name    string
+		seeded  bool
+		history []string
+		// titles of the films expected in the posted embed, nil for no embed
+		posted []string
+	}{
+		{"not seeded", false, nil, nil},
+		{"up to date", true, []string{"film6"}, nil},
+		{"two new", true, []string{"film4"}, []string{"film6", "film5"}},
+		{"at most four", true, []string{"film1"}, []string{"film6", "film5", "film4", "film3"}},
+		{"seeded while empty", true, nil, []string{"film6", "film5", "film4", "film3"}},
+	}
+
+	db := openTestDB(t)
+	follows := []Follow{}
+	for i, test := range tests {
+		channel := fmt.Sprintf("channel%d", i)
+		if err := db.Follow("testuser", channel, "guild1"); err != nil {
+			t.Fatalf("failed to insert test follow values: %v", err)
+		}
+		if test.seeded {
+			if err := db.Seed("testuser", channel, test.history); err != nil {
+				t.Fatalf("failed to insert test history: %v", err)
+			}
+		}
+		follows = append(follows, Follow{channel, test.seeded, true})
+	}
+
+	c := newRecordingClient()
+	if changed := PostUser(context.Background(), user{"testuser", follows}, db, c, src, policy); !changed {
+		t.Error("expected the feed to have new entries")
+	}
+
+	for i, test := range tests {
+		channel := fmt.Sprintf("channel%d", i)
+		embeds := c.embeds[channel]
+
+		if test.posted == nil {
+			if len(embeds) != 0 {
+				t.Errorf("%s: expected no embed, got %d", test.name, len(embeds))
+			}
+		} else if len(embeds) != 1 || c.sends[channel] != 1 {
+			t.Errorf("%s: expected one embed in one message, got %d in %d", test.name, len(embeds), c.sends[channel])
+		} else {
+			for _, title := range test.posted {
+				if !strings.Contains(embeds[0].Description, "**["+title+" (2000)]") {
+					t.Errorf("%s: expected %s in embed:\n%s", test.name, title, embeds[0].Description)
+				}
+			}
+			if n := strings.Count(embeds[0].Description, "**["); n != len(test.posted) {
+				t.Errorf("%s: expected %d films in embed, got %d", test.name, len(test.posted), n)
+			}
+		}
+
+		// Every follow is up to date afterwards
+		seen, err := db.Seen("testuser", channel, "film6")
+		if err != nil {
+			t.Fatalf("failed to check history: %v", err)
+		}
+		if !seen {
+			t.Errorf("%s: history was not updated", test.name)
+		}
+	}
+
+	// Nothing is posted twice
+	c = newRecordingClient()
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	PostUser(context.Background(), user{"testuser", users["testuser"]}, db, c, src, policy)
+	if len(c.embeds) != 0 {
+		t.Errorf("expected nothing to be posted again, got %v", c.embeds)
+	}
+}
+
+func TestPostUserSeedsCachedFeed(t *testing.T) {
+	feed := diaryFeed("film1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf("%q", fmt.Sprint(len(feed)))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	db := openTestDB(t)
+	if err := db.Follow("testuser", "channel1", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+	if err := db.Seed("testuser", "channel1", nil); err != nil {
+		t.Fatalf("failed to seed follow: %v", err)
+	}
+
+	// The feed is cached by the follow in channel1 before channel2 follows
+	c := newRecordingClient()
+	PostUser(context.Background(), user{"testuser", []Follow{{"channel1", true, true}}}, db, c, src, feedPolicy)
+	if err := db.Follow("testuser", "channel2", "guild1"); err != nil {
+		t.Fatalf("failed to insert test follow values: %v", err)
+	}
+
+	poll := func() {
+		users, err := db.GetFollows()
+		if err != nil {
+			t.Fatalf("failed to get follows: %v", err)
+		}
+		PostUser(context.Background(), user{"testuser", users["testuser"]}, db, c, src, feedPolicy)
+	}
+
+	c = newRecordingClient()
+	poll()
+	if seen, err := db.Seen("testuser", "channel2", "film1"); err != nil || !seen {
+		t.Errorf("unseeded follow was not seeded despite the cached feed: %v", err)
+	}
+	if len(c.embeds["channel2"]) != 0 {
+		t.Errorf("expected seeding not to post, got %d embeds", len(c.embeds["channel2"]))
+	}
+
+	feed = diaryFeed("film2", "film1")
+	poll()
+	for _, channel := range []string{"channel1", "channel2"} {
+		if embeds := c.embeds[channel]; len(embeds) != 1 || !strings.Contains(embeds[0].Description, "film2") {
+			t.Errorf("%s: expected the new entry to be posted, got %v", channel, embeds)
+		}
+	}
+}
+
+func TestPostUserSendFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(diaryFeed("film2", "film1")))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	db := openTestDB(t)
+	for _, channel := range []string{"channel1", "channel2"} {
+		if err := db.Follow("testuser", channel, "guild1"); err != nil {
+			t.Fatalf("failed to insert test follow values: %v", err)
+		}
+		if err := db.Seed("testuser", channel, []string{"film1"}); err != nil {
+			t.Fatalf("failed to insert test history: %v", err)
+		}
+	}
+
+	c := newRecordingClient()
+	c.err = &discordgo.RESTError{
+		Response: &http.Response{StatusCode: http.StatusForbidden},
+		Message:  &discordgo.APIErrorMessage{Code: discordgo.ErrCodeMissingPermissions, Message: "Missing Permissions"},
+	}
+
+	// Only channel1 keeps failing, channel2 recovers in between
+	for i := 0; i < maxSendFailures; i++ {
+		follows := []Follow{{"channel1", true, true}}
+		if i == 1 {
+			follows = append(follows, Follow{"channel2", true, true})
+		}
+		PostUser(context.Background(), user{"testuser", follows}, db, c, src, feedPolicy)
+	}
+	c.err = nil
+	PostUser(context.Background(), user{"testuser", []Follow{{"channel2", true, true}}}, db, c, src, feedPolicy)
+
+	users, err := db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if follows := users["testuser"]; len(follows) != 1 || follows[0].Channel != "channel2" {
+		t.Errorf("expected only the follow in channel2 to be left, got %v", follows)
+	}
+
+	resp, err := CmdFollowing(db, "channel1", "?")
+	expected := "Following the following Letterboxd usernames in this channel: testuser\n" +
+		"Posting stopped for these users after failing to post repeatedly, use `?resume` once fixed:\n" +
+		"- testuser: the bot isn't allowed to post in the channel (Missing Permissions)"
+	if err != nil || resp != expected {
+		t.Errorf("\nResponse Received: %v\nResponse Expected: %v\nError: %v", resp, expected, err)
+	}
+
+	for _, expected := range []string{"Resumed posting for 1 user in this channel.", "Nothing is stopped in this channel."} {
+		if resp, err := CmdResume(db, "channel1"); err != nil || resp != expected {
+			t.Errorf("\nResponse Received: %v\nResponse Expected: %v\nError: %v", resp, expected, err)
+		}
+	}
+
+	users, err = db.GetFollows()
+	if err != nil {
+		t.Fatalf("failed to get follows: %v", err)
+	}
+	if len(users["testuser"]) != 2 {
+		t.Errorf("expected both follows after resuming, got %v", users["testuser"])
+	}
+}
+
+func TestGenerateEmbedSpoilers(t *testing.T) {
+	feed := Feed{
+		Username:    "testuser",
+		DisplayName: "Test User",
+		Entries: []*FeedEntry{
+			{ID: "1", Title: "Eureka", Year: "2000", Review: "the ending || *twist* & all", Spoiler: true},
+		},
+	}
+
+	settings := DefaultChannelSettings()
+
+	hidden := feed.GenerateEmbeds(settings)[0].Description
+	if !strings.Contains(hidden, "```This review may contain spoilers.```") || strings.Contains(hidden, "twist") {
+		t.Errorf("spoiler review was not hidden:\n%s", hidden)
+	}
+
+	settings.SpoilerTags = true
+	tagged := feed.GenerateEmbeds(settings)[0].Description
+	if !strings.Contains(tagged, `||the ending \|\| \*twist\* & all||`) {
+		t.Errorf("spoiler review was not tagged:\n%s", tagged)
+	}
+}
+
+func TestParseEntryExtensions(t *testing.T) {
+	feed, err := gofeed.NewParser().ParseString(`<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0" xmlns:letterboxd="https://letterboxd.com" xmlns:tmdb="https://themoviedb.org">
+<channel>
+	<title>Letterboxd - Test User</title>
+	<item>
+		<guid>liked</guid>
+		<description><![CDATA[ <p>review</p> ]]></description>
+		<letterboxd:filmTitle>Chinatown</letterboxd:filmTitle>
+		<letterboxd:memberLike>Yes</letterboxd:memberLike>
+		<tmdb:movieId>829</tmdb:movieId>
+	</item>
+	<item>
+		<guid>not-liked</guid>
+		<description><![CDATA[ <p>review</p> ]]></description>
+		<letterboxd:filmTitle>Laura</letterboxd:filmTitle>
+		<letterboxd:memberLike>No</letterboxd:memberLike>
+		<tmdb:movieId>not a number</tmdb:movieId>
+	</item>
+	<item>
+		<guid>missing</guid>
+		<description><![CDATA[ <p>review</p> ]]></description>
+		<letterboxd:filmTitle>Eureka</letterboxd:filmTitle>
+	</item>
+</channel>
+</rss>`)
+	if err != nil {
+		t.Fatalf("failed to parse test feed: %v", err)
+	}
+
+	policy := bluemonday.StripTagsPolicy()
+	expected := []struct {
+		tmdbID int
+		liked  bool
+	}{
+		{829, true},
+		{0, false},
+		{0, false},
+	}
+
+	for i, item := range feed.Items {
+		entry, err := parseEntry(item, policy)
+		if err != nil {
+			t.Fatalf("failed to parse entry %s: %v", item.GUID, err)
+		}
+		if entry.TMDBID != expected[i].tmdbID || entry.Liked != expected[i].liked {
+			t.Errorf("%s: expected TMDB ID %d and liked %v, got %d and %v", item.GUID, expected[i].tmdbID, expected[i].liked, entry.TMDBID, entry.Liked)
+		}
+	}
+}
+
+// parseEntrySeeds are the seed corpus of FuzzParseEntry, also run by
+// TestParseEntrySeeds on Go versions without fuzzing: rating, watched date,
+// rewatch, year, TMDB ID, like, title and description.
+var parseEntrySeeds = [][8]string{
+	{"4.5", "2021-04-01", "Yes", "1974", "829", "Yes", "Chinatown, 1974 - ★★★★½", " <p>amazing</p> "},
+	{"5", "", "No", "", "", "No", "Eureka (contains spoilers)", ""},
+	{"", "not a date", "", "", "-1", "", "", " <p><img src=\"https://a.ltrbxd.com/resized/\"/></p> "},
+}
+
+func TestParseEntrySeeds(t *testing.T) {
+	for _, seed := range parseEntrySeeds {
+		checkParseEntry(t, seed[0], seed[1], seed[2], seed[3], seed[4], seed[5], seed[6], seed[7])
+	}
+}
+
+// checkParseEntry parses an item built from the given values, which may fail
+// but must never produce an out of range entry.
+func checkParseEntry(t *testing.T, rating, watchedDate, rewatch, year, tmdbID, like, title, description string) {
+	value := func(v string) []ext.Extension {
+		return []ext.Extension{{Value: v}}
+	}
+
+	item := &gofeed.Item{
+		Title:       title,
+		Description: description,
+		Extensions: ext.Extensions{
+			"letterboxd": {
+				"memberRating": value(rating),
+				"watchedDate":  value(watchedDate),
+				"rewatch":      value(rewatch),
+				"filmYear":     value(year),
+				"memberLike":   value(like),
+			},
+			"tmdb": {
+				"movieId": value(tmdbID),
+			},
+		},
+	}
+
+	entry, err := parseEntry(item, feedPolicy)
+	if err != nil {
+		return
+	}
+
+	if entry.Rating != Unrated && !entry.Rating.Rated() {
+		t.Errorf("rating %q parsed out of range: %d", rating, entry.Rating)
+	}
+	if entry.TMDBID < 0 {
+		t.Errorf("TMDB ID %q parsed as negative: %d", tmdbID, entry.TMDBID)
+	}
+}
+
+// TestParseFeedGolden parses the recorded feeds in testdata and compares the
+// feed and its embeds to the golden JSON next to them. Run the tests with
+// -update to rewrite the golden files after an intended change.
+func TestParseFeedGolden(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.xml"))
+	if err != nil {
+		t.Fatalf("failed to list test feeds: %v", err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no test feeds found")
+	}
+
+	policy := bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
+
+	settings := DefaultChannelSettings()
+	settings.MaxEntries = 50
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".xml")
+
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("failed to open test feed: %v", err)
+			}
+			defer f.Close()
+
+			feed, err := ParseFeed(f, "testuser", policy)
+			if err != nil {
+				t.Fatalf("failed to parse test feed: %v", err)
+			}
+
+			unseen := feed.FilterEntries(func(string) bool { return false }, settings.MaxEntries)
+
+			got, err := json.MarshalIndent(struct {
+				Feed   Feed
+				Embeds []*discordgo.MessageEmbed
+			}{feed, unseen.GenerateEmbeds(settings)}, "", "\t")
+			if err != nil {
+				t.Fatalf("failed to encode feed: %v", err)
+			}
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", name+".golden.json")
+			if *update {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+
+			if !bytes.Equal(got, expected) {
+				t.Errorf("feed differs from %s, got:\n%s", golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const (
+	defaultPollInterval = 30 * time.Minute
+	defaultPollWorkers  = 5
+	defaultPollJitter   = 30 * time.Second
+	defaultIdleAfter    = 14 * 24 * time.Hour
+	defaultIdleInterval = 6 * time.Hour
+)
+
+// Scheduler polls every followed user once per Interval. Users are spread
+// evenly across the interval instead of being fetched in a burst, and each is
+// delayed by up to Jitter on top of its slot.
+//
+// Users whose feed had no new entries for IdleAfter are only polled once
+// every IdleInterval, until their feed changes again.
+type Scheduler struct {
+	Interval     time.Duration
+	Workers      int
+	Jitter       time.Duration
+	IdleAfter    time.Duration
+	IdleInterval time.Duration
+
+	follows func() (Users, error)
+	post    func(ctx context.Context, u user) bool
+
+	lock  sync.Mutex
+	state map[string]*pollState
+}
+
+type pollState struct {
+	lastChange time.Time
+	nextPoll   time.Time
+}
+
+func NewScheduler(db *DB, c ChatClient, src FeedSource, p *bluemonday.Policy) *Scheduler {
+	return &Scheduler{
+		Interval:     defaultPollInterval,
+		Workers:      defaultPollWorkers,
+		Jitter:       defaultPollJitter,
+		IdleAfter:    defaultIdleAfter,
+		IdleInterval: defaultIdleInterval,
+		follows:      db.GetFollows,
+		post: func(ctx context.Context, u user) bool {
+			return PostUser(ctx, u, db, c, src, p)
+		},
+	}
+}
+
+// Run polls users until ctx is cancelled. The follows are reloaded at the
+// start of every cycle.
+//
+// Once ctx is cancelled no more users are handed out, and Run returns after
+// the workers finished the users they are currently posting.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	in := make(chan user)
+
+	defer func() {
+		close(in)
+		wg.Wait()
+	}()
+
+	for x := 0; x < s.Workers; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.worker(ctx, in)
+		}()
+	}
+
+	for {
+		start := time.Now()
+
+		follows, err := s.follows()
+		if err != nil {
+			log.Printf("failed to get follows: %v\n", err)
+		} else {
+			s.dispatch(ctx, in, start, s.due(follows, start))
+		}
+
+		if !sleepUntil(ctx, start.Add(s.Interval)) {
+			return
+		}
+	}
+}
+
+// dispatch hands users to the workers, one per slot of the interval.
+func (s *Scheduler) dispatch(ctx context.Context, in chan<- user, start time.Time, due []user) {
+	if len(due) == 0 {
+		return
+	}
+
+	slot := s.Interval / time.Duration(len(due))
+	for i, u := range due {
+		at := start.Add(time.Duration(i) * slot)
+		if s.Jitter > 0 {
+			at = at.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+		}
+
+		if !sleepUntil(ctx, at) {
+			return
+		}
+
+		select {
+		case in <- u:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Scheduler) worker(ctx context.Context, in <-chan user) {
+	for u := range in {
+		changed := s.post(ctx, u)
+		s.record(u.username, changed, time.Now())
+	}
+}
+
+// due returns the users to poll in this cycle, in a stable order so that
+// every user keeps roughly the same slot between cycles. It also forgets the
+// state of users that are no longer followed.
+func (s *Scheduler) due(follows Users, now time.Time) []user {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.state == nil {
+		s.state = map[string]*pollState{}
+	}
+
+	for username := range s.state {
+		if _, ok := follows[username]; !ok {
+			delete(s.state, username)
+		}
+	}
+
+	usernames := make([]string, 0, len(follows))
+	for username := range follows {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	due := []user{}
+	for _, username := range usernames {
+		state, ok := s.state[username]
+		if !ok {
+			// Nothing is known yet, so count from the first time we see them
+			state = &pollState{lastChange: now}
+			s.state[username] = state
+		}
+
+		if now.Before(state.nextPoll) {
+			continue
+		}
+
+		due = append(due, user{username, follows[username]})
+	}
+
+	return due
+}
+
+// record updates the backoff state of a user after it was polled.
+func (s *Scheduler) record(username string, changed bool, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.state[username]
+	if !ok {
+		return
+	}
+
+	if changed {
+		state.lastChange = now
+		state.nextPoll = time.Time{}
+		return
+	}
+
+	if now.Sub(state.lastChange) >= s.IdleAfter {
+		state.nextPoll = now.Add(s.IdleInterval)
+	}
+}
+
+// sleepUntil waits until t, returning false if ctx was cancelled first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
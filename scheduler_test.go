@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRun(t *testing.T) {
+	type poll struct {
+		username string
+		at       time.Time
+	}
+	polls := make(chan poll, 10)
+
+	s := &Scheduler{
+		Interval: 300 * time.Millisecond,
+		Workers:  2,
+		follows: func() (Users, error) {
+			return Users{"a": nil, "b": nil, "c": nil}, nil
+		},
+		post: func(ctx context.Context, u user) bool {
+			select {
+			case polls <- poll{u.username, time.Now()}:
+			case <-ctx.Done():
+			}
+			return true
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// Wait for every user instead of relying on timings, which slow CI
+	// runners can't keep
+	polled := map[string]time.Time{}
+	for len(polled) < 3 {
+		select {
+		case p := <-polls:
+			if _, ok := polled[p.username]; !ok {
+				polled[p.username] = p.at
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only polled %v", polled)
+		}
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop after cancel")
+	}
+
+	// Users are spread across the interval in a stable order, c gets the
+	// last slot. Timers never fire early, so only the lower bound is checked
+	if at := polled["c"]; at.Sub(start) < 200*time.Millisecond {
+		t.Errorf("user 'c' was polled %v after start, expected its slot at 200ms", at.Sub(start))
+	}
+}
+
+func TestSchedulerIdleBackoff(t *testing.T) {
+	s := &Scheduler{
+		Interval:     time.Minute,
+		IdleAfter:    time.Hour,
+		IdleInterval: 6 * time.Hour,
+	}
+
+	follows := Users{"active": nil, "idle": nil}
+	now := time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	if due := s.due(follows, now); len(due) != 2 {
+		t.Fatalf("expected both users to be due at first, got %v", due)
+	}
+
+	// Two hours without changes makes idle idle, active just posted
+	now = now.Add(2 * time.Hour)
+	s.record("active", true, now)
+	s.record("idle", false, now)
+
+	due := s.due(follows, now.Add(time.Minute))
+	if len(due) != 1 || due[0].username != "active" {
+		t.Errorf("expected only active to be due, got %v", due)
+	}
+
+	due = s.due(follows, now.Add(6*time.Hour))
+	if len(due) != 2 {
+		t.Errorf("expected idle to be due again after the idle interval, got %v", due)
+	}
+
+	// A change resets the backoff right away
+	s.record("idle", true, now.Add(6*time.Hour))
+	due = s.due(follows, now.Add(6*time.Hour+time.Minute))
+	if len(due) != 2 {
+		t.Errorf("expected idle to be due after a change, got %v", due)
+	}
+
+	// Unfollowed users are forgotten
+	s.due(Users{"active": nil}, now)
+	if _, ok := s.state["idle"]; ok {
+		t.Error("state of unfollowed user was kept")
+	}
+}
+
+func TestSchedulerDrain(t *testing.T) {
+	started := make(chan struct{})
+	var finished bool
+
+	s := &Scheduler{
+		Interval: time.Hour,
+		Workers:  1,
+		follows: func() (Users, error) {
+			return Users{"a": nil, "b": nil}, nil
+		},
+		post: func(ctx context.Context, u user) bool {
+			if u.username != "a" {
+				t.Errorf("user '%s' was handed out after cancel", u.username)
+				return false
+			}
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			finished = true
+			return true
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	<-started
+	cancel()
+	<-done
+
+	if !finished {
+		t.Error("Run returned before the in-flight user was finished")
+	}
+}
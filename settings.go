@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ChannelSettings control how entries are posted in a channel.
+type ChannelSettings struct {
+	MaxEntries   int  `json:"max_entries"`
+	ReviewLength int  `json:"review_length"`
+	Color        int  `json:"color"`
+	ShowReviews  bool `json:"show_reviews"`
+	// SpoilerTags shows reviews with spoilers behind Discord spoiler tags
+	// instead of hiding them
+	SpoilerTags bool `json:"spoiler_tags"`
+	// Format is the name of a preset format or a custom entry template
+	Format string `json:"format"`
+	// ShowLists posts the lists a user publishes, next to their diary
+	ShowLists bool `json:"show_lists"`
+}
+
+func DefaultChannelSettings() ChannelSettings {
+	return ChannelSettings{
+		MaxEntries:   4,
+		ReviewLength: 300,
+		Color:        0xd8b437,
+		ShowReviews:  true,
+		Format:       defaultFormat,
+		ShowLists:    true,
+	}
+}
+
+// validate checks settings that didn't come from `!settings`, like imported
+// ones, against the same limits.
+func (s ChannelSettings) validate() error {
+	if s.MaxEntries < 1 || s.MaxEntries > 10 {
+		return fmt.Errorf("max-entries %d is not a number from 1 to 10", s.MaxEntries)
+	}
+	if s.ReviewLength < 1 || s.ReviewLength > 1000 {
+		return fmt.Errorf("review-length %d is not a number from 1 to 1000", s.ReviewLength)
+	}
+	if s.Color < 0 || s.Color > 0xffffff {
+		return fmt.Errorf("color %d is not a color", s.Color)
+	}
+	if err := ValidateFormat(s.Format); err != nil {
+		return fmt.Errorf("format is not a preset or a valid template: %v", err)
+	}
+	return nil
+}
+
+// channelSetting describes a setting that can be changed with `!settings`.
+type channelSetting struct {
+	name        string
+	description string
+	get         func(s ChannelSettings) string
+	set         func(s *ChannelSettings, value string) error
+}
+
+var channelSettings = []channelSetting{
+	{
+		name:        "max-entries",
+		description: "maximum number of films per post, 1 to 10",
+		get: func(s ChannelSettings) string {
+			return strconv.Itoa(s.MaxEntries)
+		},
+		set: func(s *ChannelSettings, value string) error {
+			n, err := parseIntRange(value, 1, 10)
+			s.MaxEntries = n
+			return err
+		},
+	},
+	{
+		name:        "review-length",
+		description: "number of characters of a review shown before cutting it off, 1 to 1000",
+		get: func(s ChannelSettings) string {
+			return strconv.Itoa(s.ReviewLength)
+		},
+		set: func(s *ChannelSettings, value string) error {
+			n, err := parseIntRange(value, 1, 1000)
+			s.ReviewLength = n
+			return err
+		},
+	},
+	{
+		name:        "color",
+		description: "embed color as a hex code, like #d8b437",
+		get: func(s ChannelSettings) string {
+			return fmt.Sprintf("#%06x", s.Color)
+		},
+		set: func(s *ChannelSettings, value string) error {
+			color, err := strconv.ParseUint(strings.TrimPrefix(value, "#"), 16, 32)
+			if err != nil || color > 0xffffff {
+				return fmt.Errorf("%s is not a hex color like #d8b437", value)
+			}
+			s.Color = int(color)
+			return nil
+		},
+	},
+	{
+		name:        "reviews",
+		description: "whether reviews are shown, on or off",
+		get: func(s ChannelSettings) string {
+			return formatOnOff(s.ShowReviews)
+		},
+		set: func(s *ChannelSettings, value string) error {
+			on, err := parseOnOff(value)
+			s.ShowReviews = on
+			return err
+		},
+	},
+	{
+		name:        "spoilers",
+		description: "how reviews with spoilers are shown, hide or tag to show them behind spoiler tags",
+		get: func(s ChannelSettings) string {
+			if s.SpoilerTags {
+				return "tag"
+			}
+			return "hide"
+		},
+		set: func(s *ChannelSettings, value string) error {
+			switch strings.ToLower(value) {
+			case "hide":
+				s.SpoilerTags = false
+			case "tag":
+				s.SpoilerTags = true
+			default:
+				return fmt.Errorf("%s is not hide or tag", value)
+			}
+			return nil
+		},
+	},
+	{
+		name:        "format",
+		description: "how films are laid out, " + strings.Join(presetFormatNames(), ", ") + " or a custom template",
+		get: func(s ChannelSettings) string {
+			if _, ok := presetFormats[s.Format]; ok {
+				return s.Format
+			}
+			return fmt.Sprintf("`%s`", s.Format)
+		},
+		set: func(s *ChannelSettings, value string) error {
+			if err := ValidateFormat(value); err != nil {
+				return fmt.Errorf("not a preset or a valid template: %v", err)
+			}
+			s.Format = value
+			return nil
+		},
+	},
+	{
+		name:        "lists",
+		description: "whether new lists are posted, on or off",
+		get: func(s ChannelSettings) string {
+			return formatOnOff(s.ShowLists)
+		},
+		set: func(s *ChannelSettings, value string) error {
+			on, err := parseOnOff(value)
+			s.ShowLists = on
+			return err
+		},
+	},
+}
+
+func findChannelSetting(name string) (channelSetting, bool) {
+	for _, setting := range channelSettings {
+		if setting.name == name {
+			return setting, true
+		}
+	}
+	return channelSetting{}, false
+}
+
+func parseIntRange(value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s is not a number from %d to %d", value, min, max)
+	}
+	return n, nil
+}
+
+func parseOnOff(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "yes", "true":
+		return true, nil
+	case "off", "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s is not on or off", value)
+}
+
+func formatOnOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var minBackfill float64 = 1
+
+var slashCommands = []*discordgo.ApplicationCommand{
+	{
+		Name:        "follow",
+		Description: "Follow a Letterboxd user in this channel",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "username",
+				Description: "Letterboxd username",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "backfill",
+				Description: "Number of their last entries to post right away",
+				MinValue:    &minBackfill,
+				MaxValue:    maxBackfill,
+			},
+		},
+	},
+	{
+		Name:        "unfollow",
+		Description: "Unfollow a Letterboxd user in this channel",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "username",
+				Description: "Letterboxd username",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "following",
+		Description: "Show the list of currently followed users in this channel",
+	},
+	{
+		Name:        "settings",
+		Description: "Show or change the settings of this channel",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "name",
+				Description: "Setting to change",
+				Choices:     settingChoices(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "value",
+				Description: "New value of the setting",
+			},
+		},
+	},
+	{
+		Name:        "prefix",
+		Description: "Show or change the prefix of commands in this server",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "prefix",
+				Description: "New prefix",
+			},
+		},
+	},
+	{
+		Name:        "resume",
+		Description: "Resume posting for users whose posting stopped in this channel",
+	},
+	{
+		Name:        "move-follows",
+		Description: "Move every follow of this channel to another one, without posting anything again",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionChannel,
+				Name:         "channel",
+				Description:  "Channel to move the follows to",
+				ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+				Required:     true,
+			},
+		},
+	},
+	{
+		Name:        "permissions",
+		Description: "Show or change who besides administrators can manage follows and settings",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "action",
+				Description: "Grant or revoke access",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "grant", Value: "grant"},
+					{Name: "revoke", Value: "revoke"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionRole,
+				Name:        "role",
+				Description: "Manager role whose members can manage follows and settings",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "manage-channels",
+				Description: "Whether the action is about members with the Manage Channels permission",
+			},
+		},
+	},
+}
+
+func settingChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, setting := range channelSettings {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  setting.name,
+			Value: setting.name,
+		})
+	}
+	return choices
+}
+
+// RegisterSlashCommands registers the global application commands of the bot,
+// replacing any previously registered ones. The session must be open.
+func RegisterSlashCommands(s *discordgo.Session) error {
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", slashCommands)
+	return err
+}
+
+func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	// deferred is set once Discord was told that the response comes later
+	deferred := false
+
+	respond := func(text string, ephemeral bool) {
+		if deferred {
+			respondDeferred(s, i.Interaction, text, ephemeral)
+			return
+		}
+
+		data := &discordgo.InteractionResponseData{
+			Content:         text,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}
+		if ephemeral {
+			data.Flags = uint64(discordgo.MessageFlagsEphemeral)
+		}
+
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: data,
+		})
+		if err != nil {
+			log.Printf("failed to respond to interaction: %v\n", err)
+		}
+	}
+
+	// Follows belong to a guild channel, so there is nothing to do in DMs
+	if i.Member == nil {
+		respond("Commands can only be used in a server channel.", true)
+		return
+	}
+
+	data := i.ApplicationCommandData()
+
+	switch data.Name {
+	case "follow", "unfollow", "settings", "move-follows", "resume":
+		allowed, err := authorize(db, i.GuildID, i.Member.Permissions, i.Member.Roles)
+		if err != nil {
+			log.Printf("failed to authorize command: %v\n", err)
+		}
+		if !allowed {
+			respond(notAuthorized, true)
+			return
+		}
+
+	case "permissions", "prefix":
+		if i.Member.Permissions&discordgo.PermissionAdministrator == 0 {
+			respond(notAdmin, true)
+			return
+		}
+	}
+
+	// Following fetches feeds first, which can take longer than the few
+	// seconds Discord waits for a response
+	if data.Name == "follow" {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		})
+		if err != nil {
+			log.Printf("failed to defer response to interaction: %v\n", err)
+			return
+		}
+		deferred = true
+	}
+
+	var resp string
+	var err error
+
+	switch data.Name {
+	case "follow":
+		args := optionArgs(data.Options, "username")
+		for _, o := range data.Options {
+			if o.Name == "backfill" && o.Type == discordgo.ApplicationCommandOptionInteger {
+				args = append(args, "--backfill", strconv.FormatInt(o.IntValue(), 10))
+			}
+		}
+		resp, err = CmdFollow(db, NewDiscordClient(s), feedSource, feedPolicy, args, i.ChannelID, i.GuildID, "/")
+
+	case "unfollow":
+		resp, err = CmdUnfollow(db, optionArgs(data.Options, "username"), i.ChannelID, "/")
+
+	case "following":
+		resp, err = CmdFollowing(db, i.ChannelID, "/")
+
+	case "resume":
+		resp, err = CmdResume(db, i.ChannelID)
+
+	case "settings":
+		resp, err = CmdSettings(db, optionArgs(data.Options, "name", "value"), i.ChannelID, "/")
+
+	case "permissions":
+		args := optionArgs(data.Options, "action")
+		for _, o := range data.Options {
+			switch {
+			case o.Name == "role" && o.Type == discordgo.ApplicationCommandOptionRole:
+				args = append(args, fmt.Sprintf("<@&%v>", o.Value))
+			case o.Name == "manage-channels" && o.Type == discordgo.ApplicationCommandOptionBoolean && o.BoolValue():
+				args = append(args, "manage-channels")
+			}
+		}
+		resp, err = CmdPermissions(db, args, i.GuildID, "/")
+
+	case "prefix":
+		resp, err = CmdPrefix(db, optionArgs(data.Options, "prefix"), i.GuildID)
+
+	case "move-follows":
+		var args []string
+		for _, o := range data.Options {
+			if o.Name == "channel" && o.Type == discordgo.ApplicationCommandOptionChannel {
+				args = append(args, fmt.Sprintf("<#%v>", o.Value))
+			}
+		}
+		resp, err = CmdMoveFollows(db, NewDiscordClient(s), args, i.ChannelID, i.GuildID, "/")
+
+	default:
+		return
+	}
+
+	if err != nil {
+		log.Printf("failed to execute /%s: %v\n", data.Name, err)
+		if resp == "" {
+			resp = "Something went wrong, please try again later."
+		}
+		respond(resp, true)
+		return
+	}
+
+	if resp != "" {
+		respond(resp, false)
+	}
+}
+
+// respondDeferred delivers the response to an interaction that was deferred,
+// the application of a bot shares its user ID. The deferred response is
+// public, so an ephemeral one replaces it with a followup only its user sees.
+func respondDeferred(s *discordgo.Session, interaction *discordgo.Interaction, text string, ephemeral bool) {
+	if !ephemeral {
+		_, err := s.InteractionResponseEdit(s.State.User.ID, interaction, &discordgo.WebhookEdit{
+			Content:         text,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			log.Printf("failed to edit response to interaction: %v\n", err)
+		}
+		return
+	}
+
+	if err := s.InteractionResponseDelete(s.State.User.ID, interaction); err != nil {
+		log.Printf("failed to delete response to interaction: %v\n", err)
+	}
+
+	_, err := s.FollowupMessageCreate(s.State.User.ID, interaction, false, &discordgo.WebhookParams{
+		Content:         text,
+		Flags:           uint64(discordgo.MessageFlagsEphemeral),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.Printf("failed to send followup to interaction: %v\n", err)
+	}
+}
+
+// optionArgs returns the string values of the named options, in order, in the
+// same shape as the arguments of a `!` command. Missing options are skipped.
+func optionArgs(options []*discordgo.ApplicationCommandInteractionDataOption, names ...string) []string {
+	args := []string{}
+	for _, name := range names {
+		for _, o := range options {
+			if o.Name == name && o.Type == discordgo.ApplicationCommandOptionString {
+				args = append(args, o.StringValue())
+			}
+		}
+	}
+	return args
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedBaseURL   = "https://letterboxd.com/"
+	defaultFeedUserAgent = "fizzboxd (+https://github.com/the-decompiler/fizzboxd)"
+	defaultFeedTimeout   = 30 * time.Second
+)
+
+// ErrNotModified is returned by a FeedSource when the feed has not changed
+// since the fetch described by the given FeedCache.
+var ErrNotModified = errors.New("feed not modified")
+
+// FeedCache holds the HTTP validators of the last fetched feed of a member.
+type FeedCache struct {
+	ETag         string
+	LastModified string
+}
+
+// FeedSource fetches the raw RSS document of a Letterboxd member. The returned
+// FeedCache describes the fetched document and should be passed to the next
+// call for the same member.
+type FeedSource interface {
+	FetchFeed(ctx context.Context, username string, cache FeedCache) (io.ReadCloser, FeedCache, error)
+}
+
+// StatusError is returned by HTTPFeedSource for unexpected response statuses.
+// RetryAfter is set when the server asked to be retried later.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status '%s'", e.Status)
+}
+
+// HTTPFeedSource fetches feeds over HTTP from BaseURL, which defaults to
+// letterboxd.com but can point at a mirror, a caching proxy or a test server.
+type HTTPFeedSource struct {
+	BaseURL   string
+	UserAgent string
+	Timeout   time.Duration
+	Client    *http.Client
+}
+
+func NewHTTPFeedSource() *HTTPFeedSource {
+	return &HTTPFeedSource{
+		BaseURL:   defaultFeedBaseURL,
+		UserAgent: defaultFeedUserAgent,
+		Timeout:   defaultFeedTimeout,
+	}
+}
+
+func (s *HTTPFeedSource) FeedURL(username string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + username + "/rss/"
+}
+
+func (s *HTTPFeedSource) FetchFeed(ctx context.Context, username string, cache FeedCache) (io.ReadCloser, FeedCache, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.FeedURL(username), nil)
+	if err != nil {
+		return nil, cache, err
+	}
+
+	if s.UserAgent != "" {
+		req.Header.Set("User-Agent", s.UserAgent)
+	}
+	if cache.ETag != "" {
+		req.Header.Set("If-None-Match", cache.ETag)
+	}
+	if cache.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cache.LastModified)
+	}
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, cache, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, cache, ErrNotModified
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, cache, &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	fresh := FeedCache{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	return resp.Body, fresh, nil
+}
+
+// client returns the configured http.Client with Timeout applied, without
+// modifying the caller's client.
+func (s *HTTPFeedSource) client() *http.Client {
+	var c http.Client
+	if s.Client != nil {
+		c = *s.Client
+	}
+
+	if s.Timeout > 0 {
+		c.Timeout = s.Timeout
+	}
+
+	return &c
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header, delay-seconds
+// and HTTP-date. It returns 0 when the header is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
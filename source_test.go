@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+const testFeed = `<?xml version="1.0" encoding="utf-8"?>
+<rss version="2.0" xmlns:letterboxd="https://letterboxd.com" xmlns:tmdb="https://themoviedb.org">
+<channel>
+	<title>Letterboxd - Test User</title>
+	<link>https://letterboxd.com/testuser/</link>
+	<item>
+		<title>Chinatown, 1974 - ★★★★</title>
+		<link>https://letterboxd.com/testuser/film/chinatown/</link>
+		<guid isPermaLink="false">letterboxd-review-1</guid>
+		<description><![CDATA[ <p>amazing</p> ]]></description>
+		<letterboxd:watchedDate>2021-04-01</letterboxd:watchedDate>
+		<letterboxd:rewatch>No</letterboxd:rewatch>
+		<letterboxd:filmTitle>Chinatown</letterboxd:filmTitle>
+		<letterboxd:filmYear>1974</letterboxd:filmYear>
+		<letterboxd:memberRating>4.0</letterboxd:memberRating>
+	</item>
+	<item>
+		<title>Favourites</title>
+		<link>https://letterboxd.com/testuser/list/favourites/</link>
+		<guid isPermaLink="false">letterboxd-list-2</guid>
+		<description><![CDATA[ <p>some films</p> ]]></description>
+	</item>
+</channel>
+</rss>`
+
+func TestHTTPFeedSource(t *testing.T) {
+	var path, userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		userAgent = r.Header.Get("User-Agent")
+		if r.URL.Path != "/testuser/rss/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.UserAgent = "fizzboxd-test"
+	src.Client = server.Client()
+
+	policy := bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
+
+	feed, err := GetFeed(context.Background(), src, "testuser", FeedCache{}, policy)
+	if err != nil {
+		t.Fatalf("failed to get feed: %v", err)
+	}
+
+	if path != "/testuser/rss/" {
+		t.Errorf("wrong request path, expected /testuser/rss/ got %s", path)
+	}
+	if userAgent != "fizzboxd-test" {
+		t.Errorf("wrong user agent, expected fizzboxd-test got %s", userAgent)
+	}
+
+	if feed.DisplayName != "Test User" {
+		t.Errorf("wrong display name, expected Test User got %s", feed.DisplayName)
+	}
+	if len(feed.Entries) != 1 {
+		t.Fatalf("wrong number of entries, expected 1 got %d", len(feed.Entries))
+	}
+
+	e := feed.Entries[0]
+	if e.ID != "letterboxd-review-1" || e.Title != "Chinatown" || e.Year != "1974" || e.Rating != 8 || e.Review != "amazing" {
+		t.Errorf("entry parsed incorrectly: %+v", *e)
+	}
+
+	if _, err := GetFeed(context.Background(), src, "nobody", FeedCache{}, policy); err == nil {
+		t.Error("expected an error for a missing feed")
+	}
+}
+
+func TestHTTPFeedSourceNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Thu, 01 Apr 2021 12:00:00 GMT")
+		w.Write([]byte(testFeed))
+	}))
+	defer server.Close()
+
+	src := NewHTTPFeedSource()
+	src.BaseURL = server.URL
+	src.Client = server.Client()
+
+	policy := bluemonday.StripTagsPolicy().AddSpaceWhenStrippingTag(true)
+
+	feed, err := GetFeed(context.Background(), src, "testuser", FeedCache{}, policy)
+	if err != nil {
+		t.Fatalf("failed to get feed: %v", err)
+	}
+
+	expected := FeedCache{`"v1"`, "Thu, 01 Apr 2021 12:00:00 GMT"}
+	if feed.Cache != expected {
+		t.Errorf("wrong feed cache, expected %v got %v", expected, feed.Cache)
+	}
+
+	if _, err := GetFeed(context.Background(), src, "testuser", feed.Cache, policy); err != ErrNotModified {
+		t.Errorf("expected ErrNotModified, got %v", err)
+	}
+}
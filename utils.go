@@ -1,10 +1,66 @@
 package main
 
-func stringInSlice(slice []string, val string) bool {
-	for _, s := range slice {
-		if s == val {
-			return true
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var usernameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// validUsername reports whether username is a valid lowercase Letterboxd slug.
+func validUsername(username string) bool {
+	return usernameRegexp.MatchString(username)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"`", "\\`",
+)
+
+// escapeMarkdown escapes the characters Discord treats as markdown, so that
+// text can't break out of the spoiler tags or other formatting around it.
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// splitArgs splits text around whitespace like strings.Fields, but into at most
+// n fields, the last one being the untouched rest of text.
+func splitArgs(text string, n int) []string {
+	args := []string{}
+	text = strings.TrimSpace(text)
+	for text != "" {
+		if len(args) == n-1 {
+			return append(args, text)
+		}
+
+		i := strings.IndexFunc(text, unicode.IsSpace)
+		if i == -1 {
+			return append(args, text)
+		}
+
+		args = append(args, text[:i])
+		text = strings.TrimLeftFunc(text[i:], unicode.IsSpace)
+	}
+	return args
+}
+
+// usernameList lowercases usernames and drops the duplicates, keeping the
+// order they were given in.
+func usernameList(usernames []string) []string {
+	list := []string{}
+	seen := map[string]bool{}
+	for _, username := range usernames {
+		username = strings.ToLower(username)
+		if username == "" || seen[username] {
+			continue
 		}
+		seen[username] = true
+		list = append(list, username)
 	}
-	return false
+	return list
 }
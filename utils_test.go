@@ -0,0 +1,25 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		text     string
+		n        int
+		expected []string
+	}{
+		{"", 3, []string{}},
+		{"!settings", 3, []string{"!settings"}},
+		{"  !settings  color  #abcdef ", 3, []string{"!settings", "color", "#abcdef"}},
+		{"!settings format {{.Title}}\n{{stars .Rating}}", 3, []string{"!settings", "format", "{{.Title}}\n{{stars .Rating}}"}},
+	}
+
+	for _, test := range tests {
+		if got := splitArgs(test.text, test.n); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("splitArgs(%q, %d): expected %q got %q", test.text, test.n, test.expected, got)
+		}
+	}
+}